@@ -0,0 +1,206 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/spf13/afero"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssetServerOptions configures NewAssetServer and NewDirServer.
+// Prefix is stripped from request paths and Root is prepended to asset names, ie. "static" for bindata assets
+// generated from a static directory. Index defaults to index.html. With SPA set, requests for missing paths without
+// a file extension are answered with the root index so client side routing works.
+// ModTime is reported for bindata assets, which have none of their own; it defaults to the time the server was created.
+type AssetServerOptions struct {
+	Prefix       string
+	Root         string
+	Index        string
+	SPA          bool
+	CacheControl string
+	ModTime      time.Time
+}
+
+// precompressed lists the encodings served from precompressed siblings, in order of preference
+var precompressed = []struct {
+	encoding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type assetFile struct {
+	content io.ReadSeeker
+	modTime time.Time
+	etag    string
+	close   func() error
+}
+
+type assetSource interface {
+	open(name string) (*assetFile, error)
+	isDir(name string) bool
+}
+
+type bindataSource struct {
+	afn     AssetFunc
+	dfn     AssetDirFunc
+	modTime time.Time
+	etags   sync.Map
+}
+
+func (b *bindataSource) open(name string) (*assetFile, error) {
+	bits, err := b.afn(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	etag, ok := b.etags.Load(name)
+	if !ok {
+		sum := sha256.Sum256(bits)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		b.etags.Store(name, etag)
+	}
+	return &assetFile{
+		content: bytes.NewReader(bits),
+		modTime: b.modTime,
+		etag:    etag.(string),
+		close:   func() error { return nil },
+	}, nil
+}
+
+func (b *bindataSource) isDir(name string) bool {
+	if b.dfn == nil {
+		return false
+	}
+	_, err := b.dfn(name)
+	return err == nil
+}
+
+type aferoSource struct {
+	fs afero.Fs
+}
+
+func (a *aferoSource) open(name string) (*assetFile, error) {
+	f, err := a.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return &assetFile{
+		content: f,
+		modTime: info.ModTime(),
+		etag:    fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		close:   f.Close,
+	}, nil
+}
+
+func (a *aferoSource) isDir(name string) bool {
+	info, err := a.fs.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+type assetServer struct {
+	src  assetSource
+	opts AssetServerOptions
+}
+
+// NewAssetServer returns a handler serving bindata style assets, the same ones MustParseAssets renders templates from.
+// Responses carry an ETag and Last-Modified so conditional requests are answered with 304 Not Modified, and
+// precompressed siblings (name.br, name.gz) are served to clients that accept them.
+func NewAssetServer(afn AssetFunc, dfn AssetDirFunc, opts AssetServerOptions) http.Handler {
+	if opts.ModTime.IsZero() {
+		opts.ModTime = time.Now()
+	}
+	return newAssetServer(&bindataSource{afn: afn, dfn: dfn, modTime: opts.ModTime}, opts)
+}
+
+// NewDirServer is NewAssetServer for a directory on the package filesystem. Requests cannot escape dir.
+func NewDirServer(dir string, opts AssetServerOptions) http.Handler {
+	return newAssetServer(&aferoSource{fs: afero.NewBasePathFs(fs.Fs, dir)}, opts)
+}
+
+func newAssetServer(src assetSource, opts AssetServerOptions) http.Handler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	return &assetServer{src: src, opts: opts}
+}
+
+func (a *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	upath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, a.opts.Prefix))
+	name := a.name(upath)
+	if a.src.isDir(name) {
+		name = a.name(path.Join(upath, a.opts.Index))
+	}
+	if a.serve(w, r, name) {
+		return
+	}
+	if a.opts.SPA && path.Ext(upath) == "" && a.serve(w, r, a.name("/"+a.opts.Index)) {
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (a *assetServer) name(upath string) string {
+	return strings.TrimPrefix(path.Join("/", a.opts.Root, upath), "/")
+}
+
+func (a *assetServer) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	var (
+		f        *assetFile
+		encoding string
+	)
+	for _, p := range precompressed {
+		if !AcceptsEncoding(r.Header, p.encoding) {
+			continue
+		}
+		if pf, err := a.src.open(name + p.ext); err == nil {
+			f, encoding = pf, p.encoding
+			break
+		}
+	}
+	if f == nil {
+		var err error
+		if f, err = a.src.open(name); err != nil {
+			return false
+		}
+	}
+	defer f.close()
+
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if a.opts.CacheControl != "" {
+		h.Set("Cache-Control", a.opts.CacheControl)
+	}
+	etag := f.etag
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		// the compressed bytes cannot be sniffed, so the type has to come from the original name
+		ctype := mime.TypeByExtension(path.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("ETag", etag)
+	http.ServeContent(w, r, name, f.modTime, f.content)
+	return true
+}
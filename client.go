@@ -0,0 +1,250 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client is a json http client bound to a base url. Header and Auth are applied to every request.
+type Client struct {
+	BaseURL    *url.URL
+	Header     http.Header
+	Auth       RequestFunc
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client for baseURL whose transport is wrapped in wares, see WrapClient
+func NewClient(baseURL string, wares ...Tripperware) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid base url %q: %s", baseURL, err)
+	}
+	return &Client{
+		BaseURL:    u,
+		Header:     http.Header{"Accept": {"application/json"}},
+		HTTPClient: WrapClient(&http.Client{Timeout: 30 * time.Second}, wares...),
+	}, nil
+}
+
+// BasicAuth returns a RequestFunc setting basic auth credentials
+func BasicAuth(user, password string) RequestFunc {
+	return func(req *http.Request) {
+		req.SetBasicAuth(user, password)
+	}
+}
+
+// BearerAuth returns a RequestFunc setting a bearer token, ie. one from GenerateJWT
+func BearerAuth(token string) RequestFunc {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// ResponseError is returned by Client for responses with a non-2xx status. Message is taken from a "message" or
+// "error" field of a json body when present.
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// URL resolves path, which may contain a query string, against the base url
+func (c *Client) URL(path string) (*url.URL, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	u := *c.BaseURL
+	if ref.Path != "" {
+		u.Path = SingleJoiningSlash(u.Path, ref.Path)
+	}
+	if ref.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&" + ref.RawQuery
+		} else {
+			u.RawQuery = ref.RawQuery
+		}
+	}
+	return &u, nil
+}
+
+// NewRequest builds a request for path, encoding in as json unless it is nil, an io.Reader or a []byte
+func (c *Client) NewRequest(ctx context.Context, method, path string, in interface{}) (*http.Request, error) {
+	u, err := c.URL(path)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		body        io.Reader
+		contentType string
+	)
+	switch v := in.(type) {
+	case nil:
+	case io.Reader:
+		body = v
+	case []byte:
+		body = bytes.NewReader(v)
+	default:
+		bits, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("client: failed to encode request body: %s", err)
+		}
+		body = bytes.NewReader(bits)
+		contentType = "application/json"
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	CopyHeader(req.Header, c.Header)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Auth != nil {
+		c.Auth(req)
+	}
+	return req, nil
+}
+
+// Do sends req and decodes a json response into out unless it is nil. If out is an io.Writer the body is copied to it instead.
+// Responses with a non-2xx status are returned as a *ResponseError.
+func (c *Client) Do(req *http.Request, out interface{}) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, newResponseError(req, resp)
+	}
+	switch v := out.(type) {
+	case nil:
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	case io.Writer:
+		_, err = io.Copy(v, resp.Body)
+	default:
+		if resp.StatusCode == http.StatusNoContent {
+			return resp, nil
+		}
+		if err = json.NewDecoder(resp.Body).Decode(out); err == io.EOF {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("client: failed to decode response body: %s", err)
+		}
+	}
+	return resp, err
+}
+
+func newResponseError(req *http.Request, resp *http.Response) *ResponseError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	e := &ResponseError{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	var msg struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+		Detail  string `json:"detail"`
+	}
+	if json.Unmarshal(body, &msg) == nil {
+		switch {
+		case msg.Message != "":
+			e.Message = msg.Message
+		case msg.Error != "":
+			e.Message = msg.Error
+		case msg.Detail != "":
+			e.Message = msg.Detail
+		}
+	}
+	return e
+}
+
+// Call builds and sends a request in one step, see NewRequest and Do
+func (c *Client) Call(ctx context.Context, method, path string, in, out interface{}) error {
+	req, err := c.NewRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	_, err = c.Do(req, out)
+	return err
+}
+
+// Get sends a GET request, decoding the response into out
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return c.Call(ctx, http.MethodGet, path, nil, out)
+}
+
+// Post sends in as a POST request, decoding the response into out
+func (c *Client) Post(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPost, path, in, out)
+}
+
+// Put sends in as a PUT request, decoding the response into out
+func (c *Client) Put(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPut, path, in, out)
+}
+
+// Patch sends in as a PATCH request, decoding the response into out
+func (c *Client) Patch(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPatch, path, in, out)
+}
+
+// Delete sends a DELETE request, decoding the response into out
+func (c *Client) Delete(ctx context.Context, path string, out interface{}) error {
+	return c.Call(ctx, http.MethodDelete, path, nil, out)
+}
+
+// LoggingTripperware logs the method, url, status and duration of every request with logf, or through the logger
+// from the request context (see LoggerFromContext) if logf is nil
+func LoggingTripperware(logf func(format string, args ...interface{})) Tripperware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			fields := Fields{"method": req.Method, "url": req.URL.Redacted(), "duration": time.Since(start).String()}
+			if err != nil {
+				if logf != nil {
+					logf("%s %s error=%q duration=%s", req.Method, req.URL.Redacted(), err, time.Since(start))
+				} else {
+					fields["error"] = err
+					LoggerFromContext(req.Context()).Error("request failed", fields)
+				}
+				return resp, err
+			}
+			if logf != nil {
+				logf("%s %s status=%d duration=%s", req.Method, req.URL.Redacted(), resp.StatusCode, time.Since(start))
+			} else {
+				fields["status"] = resp.StatusCode
+				LoggerFromContext(req.Context()).Info("request", fields)
+			}
+			return resp, nil
+		})
+	}
+}
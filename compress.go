@@ -0,0 +1,308 @@
+package util
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressSkipTypes are content type prefixes that are already compressed and not worth compressing again
+var DefaultCompressSkipTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/wasm", "application/octet-stream",
+	"application/pdf",
+}
+
+// CompressOptions configures Compress. Responses smaller than MinSize, which defaults to 1KB, are sent as is.
+// Level is passed to every encoder; 0 uses each encoder's default. SkipTypes defaults to DefaultCompressSkipTypes.
+type CompressOptions struct {
+	MinSize   int
+	Level     int
+	SkipTypes []string
+}
+
+// compressEncodings lists supported encodings in order of preference
+var compressEncodings = []string{"br", "gzip", "deflate"}
+
+func newEncoder(encoding string, w io.Writer, level int) (io.WriteCloser, error) {
+	switch encoding {
+	case "br":
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "deflate":
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return zlib.NewWriterLevel(w, level)
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// Compress returns middleware compressing responses with brotli, gzip or deflate according to Accept-Encoding.
+// Responses that are small, already encoded, partial or of a skipped content type are passed through untouched.
+// The response writer implements http.Flusher, so streamed responses are flushed through the encoder as they are written.
+func Compress(opts CompressOptions) HandlerFunc {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.SkipTypes == nil {
+		opts.SkipTypes = DefaultCompressSkipTypes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := ""
+			for _, enc := range compressEncodings {
+				if AcceptsEncoding(r.Header, enc) {
+					encoding = enc
+					break
+				}
+			}
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, opts: &opts}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	opts     *CompressOptions
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < c.opts.MinSize {
+			return len(p), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.enc != nil {
+		return c.enc.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// decide commits the response headers, compressing if allowed and the body is large enough or being streamed
+func (c *compressWriter) decide(large bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	h := c.Header()
+	if large && c.compressible() {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", c.encoding)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		enc, err := newEncoder(c.encoding, c.ResponseWriter, c.opts.Level)
+		if err != nil {
+			return err
+		}
+		c.enc = enc
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+func (c *compressWriter) compressible() bool {
+	if c.status < 200 || c.status == http.StatusNoContent || c.status == http.StatusNotModified || c.status == http.StatusPartialContent {
+		return false
+	}
+	h := c.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	ctype := h.Get("Content-Type")
+	if ctype == "" {
+		ctype = http.DetectContentType(c.buf)
+		h.Set("Content-Type", ctype)
+	}
+	ctype = strings.ToLower(ctype)
+	for _, skip := range c.opts.SkipTypes {
+		if strings.HasPrefix(ctype, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush commits the response, compressing it even if it is below MinSize, and flushes any buffered output to the client
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			c.status = http.StatusOK
+		}
+		c.decide(true)
+	}
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler if nothing has been written yet, ie. for websocket upgrades
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok || c.decided || c.status != 0 {
+		return nil, nil, fmt.Errorf("compress: response writer cannot be hijacked")
+	}
+	c.decided = true
+	return hj.Hijack()
+}
+
+// Close finishes the response once the handler returns
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 {
+			// the handler wrote nothing
+			c.decided = true
+			return nil
+		}
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+	if c.enc != nil {
+		return c.enc.Close()
+	}
+	return nil
+}
+
+// DecompressTripperware advertises brotli, gzip and deflate support on requests that do not set Accept-Encoding
+// themselves and transparently decodes responses in any of them. Bodies are decoded lazily on the first Read, so
+// responses without a body, ie. to HEAD requests or with status 204 or 304, are returned untouched.
+func DecompressTripperware() Tripperware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set("Accept-Encoding", "br, gzip, deflate")
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+				return resp, nil
+			}
+			encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+			switch encoding {
+			case "br", "gzip", "x-gzip", "deflate":
+			default:
+				return resp, nil
+			}
+			resp.Body = &decodedBody{encoding: encoding, body: resp.Body}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			resp.Uncompressed = true
+			return resp, nil
+		})
+	}
+}
+
+// newDecoder returns a reader decoding body in one of the encodings DecompressTripperware handles
+func newDecoder(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "br":
+		return brotli.NewReader(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some servers send raw deflate
+		br := bufio.NewReader(body)
+		if head, err := br.Peek(2); err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// decodedBody creates its decoder on the first Read, as reading the gzip and zlib headers fails on an empty body
+type decodedBody struct {
+	encoding string
+	body     io.ReadCloser
+	r        io.Reader
+	err      error
+	once     sync.Once
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		r, err := newDecoder(d.encoding, d.body)
+		if err != nil {
+			d.err = err
+			return 0, err
+		}
+		d.r = r
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decodedBody) Close() error {
+	var err error
+	d.once.Do(func() {
+		if c, ok := d.r.(io.Closer); ok {
+			c.Close()
+		}
+		err = d.body.Close()
+	})
+	return err
+}
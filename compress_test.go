@@ -0,0 +1,77 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decompressClient() *http.Client {
+	return &http.Client{Transport: DecompressTripperware()(http.DefaultTransport)}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := strings.Repeat("compress me ", 500)
+	for _, enc := range []string{"br", "gzip", "deflate"} {
+		srv := httptest.NewServer(Compress(CompressOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, body)
+		})))
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Accept-Encoding", enc)
+		raw, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.Body.Close()
+		if got := raw.Header.Get("Content-Encoding"); got != enc {
+			t.Fatalf("expected %s encoding, got %q", enc, got)
+		}
+
+		resp, err := decompressClient().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", enc, err)
+		}
+		if string(got) != body {
+			t.Fatalf("%s: body was not decoded", enc)
+		}
+	}
+}
+
+func TestDecompressTripperwareEmptyBodies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/notmodified":
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}))
+	defer srv.Close()
+	client := decompressClient()
+
+	resp, err := client.Head(srv.URL)
+	if err != nil {
+		t.Fatalf("HEAD: %s", err)
+	}
+	resp.Body.Close()
+	for _, path := range []string{"/", "/nocontent", "/notmodified"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatalf("GET %s: reading body: %s", path, err)
+		}
+		resp.Body.Close()
+	}
+}
@@ -18,41 +18,60 @@ func InitConfig(cfgFile string, envPrefix string) {
 	viper.AutomaticEnv() // read in environment variables that match
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		GetLogger().Info("using config file", Fields{"file": viper.ConfigFileUsed()})
 	}
 }
 
 func SyncEnvConfig() {
+	if err := SyncEnvConfigErr(); err != nil {
+		logFatal("failed to bind config to env variable", Fields{"error": err})
+	}
+}
+
+// SyncEnvConfigErr is SyncEnvConfig returning an error instead of exiting
+func SyncEnvConfigErr() error {
 	for _, e := range os.Environ() {
-		sp := strings.Split(e, "=")
-		viper.SetDefault(strings.ToLower(sp[0]), sp[1])
+		sp := strings.SplitN(e, "=", 2)
+		if len(sp) == 2 {
+			viper.SetDefault(strings.ToLower(sp[0]), sp[1])
+		}
 	}
 	for k, v := range viper.AllSettings() {
 		val, ok := v.(string)
 		if ok {
 			if err := os.Setenv(k, val); err != nil {
-				fmt.Println("failed to bind config to env variable", err.Error())
-				os.Exit(1)
+				return fmt.Errorf("failed to set %s: %s", k, err)
 			}
 		}
 	}
+	return nil
 }
 
 func RenderFromConfig(s string) string {
 	return Render(s, viper.AllSettings())
 }
 
+// RenderFromConfigErr is RenderFromConfig returning an error instead of exiting
+func RenderFromConfigErr(s string) (string, error) {
+	return RenderErr(s, viper.AllSettings())
+}
+
 func YamlFromConfig() []byte {
-	bits, err := yaml.Marshal(viper.AllSettings())
+	bits, err := YamlFromConfigErr()
 	if err != nil {
-		fmt.Println("failed to unmarshal current settings to yaml", err.Error())
-		os.Exit(1)
+		logFatal("failed to unmarshal current settings to yaml", Fields{"error": err})
 	}
 	return bits
 }
 
+// YamlFromConfigErr is YamlFromConfig returning an error instead of exiting. Secrets are redacted (see RedactedSettings).
+func YamlFromConfigErr() ([]byte, error) {
+	return yaml.Marshal(RedactedSettings())
+}
+
+// JsonFromConfig returns the current settings as pretty json with secrets redacted (see RedactedSettings)
 func JsonFromConfig() []byte {
-	return ToPrettyJson(viper.AllSettings())
+	return ToPrettyJson(RedactedSettings())
 }
 
 func DotEnv() error {
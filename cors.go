@@ -0,0 +1,189 @@
+package util
+
+import (
+	"fmt"
+	"github.com/rs/cors"
+	"github.com/spf13/viper"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CorsConfig is a cors policy. It can be loaded from a config file with CorsConfigFromViper, ie.
+//
+//	cors:
+//	  origins: ["https://example.com", "https://*.example.com"]
+//	  origin_patterns: ["^https://pr-[0-9]+\\.preview\\.example\\.com$"]
+//	  creds: true
+//	  routes:
+//	    - path: /public/
+//	      origins: ["*"]
+//
+// Origins may contain * wildcards, ie. for subdomains. OriginPatterns are regular expressions matched against the whole origin.
+// Routes are standalone policies applied to requests whose path starts with Path. The longest matching Path wins.
+type CorsConfig struct {
+	Origins        []string    `mapstructure:"origins" json:"origins" yaml:"origins"`
+	OriginPatterns []string    `mapstructure:"origin_patterns" json:"origin_patterns" yaml:"origin_patterns"`
+	Methods        []string    `mapstructure:"methods" json:"methods" yaml:"methods"`
+	Headers        []string    `mapstructure:"headers" json:"headers" yaml:"headers"`
+	ExposedHeaders []string    `mapstructure:"exposed_headers" json:"exposed_headers" yaml:"exposed_headers"`
+	Creds          bool        `mapstructure:"creds" json:"creds" yaml:"creds"`
+	Options        bool        `mapstructure:"options" json:"options" yaml:"options"`
+	Debug          bool        `mapstructure:"debug" json:"debug" yaml:"debug"`
+	MaxAge         int         `mapstructure:"max_age" json:"max_age" yaml:"max_age"`
+	Routes         []CorsRoute `mapstructure:"routes" json:"routes" yaml:"routes"`
+}
+
+// CorsRoute is a cors policy for requests under a path prefix
+type CorsRoute struct {
+	Path       string `mapstructure:"path" json:"path" yaml:"path"`
+	CorsConfig `mapstructure:",squash" yaml:",inline"`
+}
+
+// CorsConfigFromViper loads a CorsConfig from the viper sub-tree at key
+func CorsConfigFromViper(key string) (*CorsConfig, error) {
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("cors: no config found at key %s", key)
+	}
+	cfg := &CorsConfig{}
+	if err := viper.UnmarshalKey(key, cfg); err != nil {
+		return nil, fmt.Errorf("cors: failed to decode config at key %s: %s", key, err)
+	}
+	return cfg, nil
+}
+
+// CorsOptions converts the policy to rs/cors options, filling in the same defaults as NewCors.
+// Route policies are ignored; use NewCorsHandler to apply them.
+func (c *CorsConfig) CorsOptions() (cors.Options, error) {
+	opts := cors.Options{
+		AllowedOrigins:     c.Origins,
+		AllowedMethods:     c.Methods,
+		AllowedHeaders:     c.Headers,
+		ExposedHeaders:     c.ExposedHeaders,
+		MaxAge:             c.MaxAge,
+		AllowCredentials:   c.Creds,
+		OptionsPassthrough: c.Options,
+		Debug:              c.Debug,
+	}
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		}
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = []string{"*"}
+	}
+	if len(c.OriginPatterns) == 0 {
+		if len(opts.AllowedOrigins) == 0 {
+			opts.AllowedOrigins = []string{"*"}
+		}
+		return opts, nil
+	}
+	match, err := originMatcher(c.Origins, c.OriginPatterns)
+	if err != nil {
+		return opts, err
+	}
+	opts.AllowOriginFunc = match
+	return opts, nil
+}
+
+// originMatcher compiles exact, wildcard and regular expression origins into a single func, since rs/cors ignores
+// AllowedOrigins once AllowOriginFunc is set.
+func originMatcher(origins, patterns []string) (func(string) bool, error) {
+	var exprs []*regexp.Regexp
+	for _, o := range origins {
+		if o == "*" {
+			return func(string) bool { return true }, nil
+		}
+		parts := strings.Split(strings.ToLower(o), "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		exprs = append(exprs, regexp.MustCompile("^"+strings.Join(parts, "[a-z0-9.-]*")+"$"))
+	}
+	for _, p := range patterns {
+		// anchored so a pattern cannot match a prefix of a hostile origin, ie. https://a.example.com.evil.io
+		expr, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cors: invalid origin pattern %q: %s", p, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		for _, expr := range exprs {
+			if expr.MatchString(origin) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// NewCorsFromConfig returns a cors handler for the policy, ignoring route policies
+func NewCorsFromConfig(cfg *CorsConfig) (*cors.Cors, error) {
+	opts, err := cfg.CorsOptions()
+	if err != nil {
+		return nil, err
+	}
+	return cors.New(opts), nil
+}
+
+// NewCorsHandler returns middleware applying the policy and its route policies, ie. to wrap a mux
+func NewCorsHandler(cfg *CorsConfig) (HandlerFunc, error) {
+	base, err := NewCorsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	type route struct {
+		path string
+		cors *cors.Cors
+	}
+	routes := make([]route, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		c, err := NewCorsFromConfig(&r.CorsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cors: route %s: %s", r.Path, err)
+		}
+		routes = append(routes, route{path: r.Path, cors: c})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].path) > len(routes[j].path)
+	})
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(routes))
+		for i, r := range routes {
+			handlers[i] = r.cors.Handler(next)
+		}
+		fallback := base.Handler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			for i, r := range routes {
+				if strings.HasPrefix(req.URL.Path, r.path) {
+					handlers[i].ServeHTTP(w, req)
+					return
+				}
+			}
+			fallback.ServeHTTP(w, req)
+		})
+	}, nil
+}
+
+// NewCors returns a cors handler from positional options. Prefer NewCorsFromConfig.
+func NewCors(origins, methods, headers []string, creds, options, debug bool, maxAge int) *cors.Cors {
+	c, _ := NewCorsFromConfig(&CorsConfig{
+		Origins: origins,
+		Methods: methods,
+		Headers: headers,
+		Creds:   creds,
+		Options: options,
+		Debug:   debug,
+		MaxAge:  maxAge,
+	})
+	return c
+}
@@ -0,0 +1,20 @@
+package util
+
+import "testing"
+
+func TestOriginMatcherAnchorsPatterns(t *testing.T) {
+	match, err := originMatcher([]string{"https://*.example.org"}, []string{`https://.*\.example\.com`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"https://a.example.com":         true,
+		"https://a.example.com.evil.io": false,
+		"https://b.example.org":         true,
+		"https://b.example.org.evil.io": false,
+	} {
+		if got := match(origin); got != want {
+			t.Errorf("origin %s: expected %v, got %v", origin, want, got)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -23,6 +24,7 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/scrypt"
 	"hash/adler32"
+	"io"
 	"math/big"
 	"net"
 	"os"
@@ -34,6 +36,15 @@ func Sha256sum(input string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// Sha256sumReader returns the hex encoded sha256 of everything read from r
+func Sha256sumReader(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func Sha1sum(input string) string {
 	hash := sha1.Sum([]byte(input))
 	return hex.EncodeToString(hash[:])
@@ -213,6 +224,11 @@ func BuildCustomCertificate(b64cert string, b64key string) (Certificate, error)
 	return crt, nil
 }
 
+// TLSCertificate parses the pem encoded certificate and key for use in a tls.Config
+func (c Certificate) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+}
+
 func GenerateCertificateAuthority(
 	cn string,
 	daysValid int,
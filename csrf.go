@@ -0,0 +1,182 @@
+package util
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"html/template"
+	"net/http"
+)
+
+// CSRFMode selects how a csrf token is stored between requests
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit stores the token in a cookie and expects the client to echo it back in a header or form field.
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer stores the token in a session and expects the client to submit it in a header or form field.
+	CSRFSynchronizer
+)
+
+const (
+	DefaultCSRFCookieName  = "_csrf"
+	DefaultCSRFSessionName = "_csrf"
+	DefaultCSRFHeaderName  = "X-CSRF-Token"
+	DefaultCSRFFieldName   = "csrf_token"
+	DefaultCSRFTokenLength = 32
+
+	csrfSessionKey = "csrf_token"
+)
+
+type csrfContextKey struct{}
+
+// CSRFConfig configures the middleware returned by NewCSRF. Zero values fall back to the DefaultCSRF* constants.
+type CSRFConfig struct {
+	Mode CSRFMode
+	// Store is required in CSRFSynchronizer mode, ie. the store returned by NewSessionCookieStore
+	Store       sessions.Store
+	SessionName string
+	CookieName  string
+	CookiePath  string
+	Secure      bool
+	HeaderName  string
+	FieldName   string
+	TokenLength int
+	// ErrorFunc is called when a token is missing or invalid. Defaults to a 403 Forbidden response.
+	ErrorFunc ErrorFunc
+}
+
+func (c *CSRFConfig) setDefaults() {
+	if c.SessionName == "" {
+		c.SessionName = DefaultCSRFSessionName
+	}
+	if c.CookieName == "" {
+		c.CookieName = DefaultCSRFCookieName
+	}
+	if c.CookiePath == "" {
+		c.CookiePath = "/"
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = DefaultCSRFHeaderName
+	}
+	if c.FieldName == "" {
+		c.FieldName = DefaultCSRFFieldName
+	}
+	if c.TokenLength <= 0 {
+		c.TokenLength = DefaultCSRFTokenLength
+	}
+	if c.ErrorFunc == nil {
+		c.ErrorFunc = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	}
+}
+
+// NewCSRF returns middleware that issues a csrf token on every request and validates it on unsafe methods (anything other than GET, HEAD, OPTIONS and TRACE).
+// The token for the current request is available to handlers via CSRFToken and to templates via CSRFFuncMap.
+func NewCSRF(cfg CSRFConfig) HandlerFunc {
+	cfg.setDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var (
+				token string
+				sess  *sessions.Session
+			)
+			switch cfg.Mode {
+			case CSRFSynchronizer:
+				if cfg.Store == nil {
+					cfg.ErrorFunc(w, r, NewErr("csrf: synchronizer mode requires a session store"))
+					return
+				}
+				var err error
+				sess, err = cfg.Store.Get(r, cfg.SessionName)
+				if err != nil && sess == nil {
+					cfg.ErrorFunc(w, r, fmt.Errorf("csrf: failed to load session: %s", err))
+					return
+				}
+				token, _ = sess.Values[csrfSessionKey].(string)
+			default:
+				if c, err := r.Cookie(cfg.CookieName); err == nil {
+					token = c.Value
+				}
+			}
+
+			if !isSafeMethod(r.Method) {
+				if token == "" {
+					cfg.ErrorFunc(w, r, NewErr("csrf: token not found"))
+					return
+				}
+				sent := r.Header.Get(cfg.HeaderName)
+				if sent == "" {
+					sent = r.PostFormValue(cfg.FieldName)
+				}
+				if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					cfg.ErrorFunc(w, r, NewErr("csrf: invalid token"))
+					return
+				}
+			}
+
+			if token == "" {
+				token = RandomToken(cfg.TokenLength)
+				switch cfg.Mode {
+				case CSRFSynchronizer:
+					sess.Values[csrfSessionKey] = token
+					if err := sess.Save(r, w); err != nil {
+						cfg.ErrorFunc(w, r, fmt.Errorf("csrf: failed to save session: %s", err))
+						return
+					}
+				default:
+					http.SetCookie(w, &http.Cookie{
+						Name:   cfg.CookieName,
+						Value:  token,
+						Path:   cfg.CookiePath,
+						Secure: cfg.Secure,
+						// left readable by scripts so they can echo it back in the csrf header
+						HttpOnly: false,
+					})
+				}
+			}
+			w.Header().Add("Vary", "Cookie")
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, &csrfContext{token: token, field: cfg.FieldName})))
+		})
+	}
+}
+
+type csrfContext struct {
+	token string
+	field string
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// CSRFToken returns the csrf token issued to the request by the NewCSRF middleware, or an empty string if there is none
+func CSRFToken(r *http.Request) string {
+	if c, ok := r.Context().Value(csrfContextKey{}).(*csrfContext); ok {
+		return c.token
+	}
+	return ""
+}
+
+// CSRFField returns a hidden form input holding the csrf token issued to the request by the NewCSRF middleware
+func CSRFField(r *http.Request) template.HTML {
+	c, ok := r.Context().Value(csrfContextKey{}).(*csrfContext)
+	if !ok {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, template.HTMLEscapeString(c.field), template.HTMLEscapeString(c.token)))
+}
+
+// CSRFFuncMap returns the template functions csrfToken and csrfField bound to the request. Pass it to RenderFilesToResponseWriterFuncs or MustExecHtmlAssetsFuncs.
+func CSRFFuncMap(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string { return CSRFToken(r) },
+		"csrfField": func() template.HTML { return CSRFField(r) },
+	}
+}
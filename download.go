@@ -0,0 +1,270 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DownloadOptions configures Download. Parallel splits the download into that many concurrent range requests when
+// the server supports them. SHA256 is the expected hex encoded checksum of the file; it is not verified when empty.
+// Progress, if set, is called with the bytes downloaded so far, including any resumed from a previous attempt, and
+// the total size, which is -1 if unknown.
+type DownloadOptions struct {
+	Client   *http.Client
+	Header   http.Header
+	Parallel int
+	SHA256   string
+	Progress func(downloaded, total int64)
+}
+
+var errDownloadChanged = errors.New("download: remote file changed")
+
+type downloadInfo struct {
+	size      int64
+	ranges    bool
+	validator string
+}
+
+type downloadChunk struct {
+	path       string
+	start, end int64 // inclusive, end is -1 for "until eof"
+}
+
+// Download fetches url to dest through the package filesystem. Partial downloads are kept next to dest with a
+// .part suffix and resumed with range requests on the next call. The file is only renamed to dest once it is
+// complete and its checksum matches.
+func Download(ctx context.Context, url, dest string, opts DownloadOptions) error {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+	info, err := probeDownload(ctx, url, opts)
+	if err != nil {
+		return err
+	}
+
+	part := dest + ".part"
+	chunks := []downloadChunk{{path: part, start: 0, end: info.size - 1}}
+	if info.size < 0 {
+		chunks[0].end = -1
+	}
+	if opts.Parallel > 1 && info.ranges && info.size >= int64(opts.Parallel) {
+		chunks = chunks[:0]
+		size := info.size / int64(opts.Parallel)
+		for i := 0; i < opts.Parallel; i++ {
+			c := downloadChunk{path: fmt.Sprintf("%s.%d", part, i), start: int64(i) * size, end: int64(i+1)*size - 1}
+			if i == opts.Parallel-1 {
+				c.end = info.size - 1
+			}
+			chunks = append(chunks, c)
+		}
+	}
+
+	var downloaded int64
+	progress := func(n int64) {
+		if opts.Progress != nil {
+			opts.Progress(atomic.AddInt64(&downloaded, n), info.size)
+		}
+	}
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+	for i, c := range chunks {
+		wg.Add(1)
+		go func(i int, c downloadChunk) {
+			defer wg.Done()
+			errs[i] = downloadChunkTo(ctx, url, c, info, opts, progress)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == errDownloadChanged {
+			for _, c := range chunks {
+				fs.Remove(c.path)
+			}
+			return fmt.Errorf("download: %s changed since the partial download started, try again", url)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(chunks) > 1 {
+		if err := joinChunks(part, chunks); err != nil {
+			return err
+		}
+	}
+	if opts.SHA256 != "" {
+		f, err := fs.Open(part)
+		if err != nil {
+			return err
+		}
+		sum, err := Sha256sumReader(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, opts.SHA256) {
+			fs.Remove(part)
+			return fmt.Errorf("download: checksum mismatch for %s: expected %s, got %s", url, opts.SHA256, sum)
+		}
+	}
+	return fs.Rename(part, dest)
+}
+
+// probeDownload asks the server for the size of the file and whether it supports range requests
+func probeDownload(ctx context.Context, url string, opts DownloadOptions) (*downloadInfo, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	CopyHeader(req.Header, opts.Header)
+	resp, err := opts.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := &downloadInfo{size: -1}
+	if resp.StatusCode != http.StatusOK {
+		// some servers do not implement HEAD, fall back to a plain sequential download
+		return info, nil
+	}
+	info.size = resp.ContentLength
+	info.ranges = HeaderContainsToken(resp.Header, "Accept-Ranges", "bytes") && info.size > 0
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		info.validator = etag
+	} else {
+		info.validator = resp.Header.Get("Last-Modified")
+	}
+	return info, nil
+}
+
+func downloadChunkTo(ctx context.Context, url string, c downloadChunk, info *downloadInfo, opts DownloadOptions, progress func(int64)) error {
+	var have int64
+	if st, err := fs.Stat(c.path); err == nil && info.ranges {
+		have = st.Size()
+	}
+	if c.end >= 0 && c.start+have > c.end {
+		progress(c.end - c.start + 1)
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	CopyHeader(req.Header, opts.Header)
+	ranged := info.ranges && (have > 0 || c.start > 0 || c.end < info.size-1)
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", c.start+have, c.end))
+		if info.validator != "" {
+			req.Header.Set("If-Range", info.validator)
+		}
+	}
+	resp, err := opts.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	switch {
+	case ranged && resp.StatusCode == http.StatusPartialContent:
+		if start, _, _, err := ParseContentRange(resp.Header.Get("Content-Range")); err != nil || start != c.start+have {
+			return fmt.Errorf("download: server returned range %q, requested %s", resp.Header.Get("Content-Range"), req.Header.Get("Range"))
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		progress(have)
+	case resp.StatusCode == http.StatusOK && c.start == 0:
+		// the file changed or ranges were ignored, start over
+	case resp.StatusCode == http.StatusOK:
+		return errDownloadChanged
+	default:
+		return fmt.Errorf("download: unexpected status %s for %s (range %s)", resp.Status, url, req.Header.Get("Range"))
+	}
+	f, err := fs.OpenFile(c.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	var body io.Reader = resp.Body
+	if resp.StatusCode == http.StatusOK && c.end >= 0 {
+		body = io.LimitReader(resp.Body, c.end-c.start+1)
+	}
+	_, err = io.Copy(f, &progressReader{r: body, progress: progress})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func joinChunks(part string, chunks []downloadChunk) error {
+	out, err := fs.Create(part)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		in, err := fs.Open(c.path)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		fs.Remove(c.path)
+	}
+	return nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return n, err
+}
+
+// ParseContentRange parses a Content-Range header of the form "bytes start-end/size", returning -1 for an unknown size
+func ParseContentRange(v string) (start, end, size int64, err error) {
+	v = strings.TrimSpace(strings.TrimPrefix(v, "bytes "))
+	slash := strings.IndexByte(v, '/')
+	dash := strings.IndexByte(v, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	if start, err = strconv.ParseInt(v[:dash], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+	if end, err = strconv.ParseInt(v[dash+1:slash], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+	if v[slash+1:] == "*" {
+		return start, end, -1, nil
+	}
+	size, err = strconv.ParseInt(v[slash+1:], 10, 64)
+	return start, end, size, err
+}
@@ -1,28 +1,42 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+	"io"
+	"net/http"
 	"os"
+	"runtime"
+	"strings"
 )
 
 func FatalIfErr(e error, msg string, arg interface{}) {
 	if e != nil {
-		logrus.Fatalf("Error: %v Msg: %v Arg: %v", e, msg, arg)
+		logFatal(msg, Fields{"error": e, "arg": arg})
 	}
 }
 
 func PrintIfErr(e error, msg string, arg interface{}) {
 	if e != nil {
-		logrus.Printf("Error: %v Msg: %v Arg: %v", e, msg, arg)
+		GetLogger().Error(msg, Fields{"error": e, "arg": arg})
 	}
 }
 
 func Exit(code int, format string, args ...interface{}) {
-	fmt.Printf(format, args...)
+	msg := strings.TrimSpace(fmt.Sprintf(format, args...))
+	if code == 0 {
+		GetLogger().Info(msg)
+	} else {
+		GetLogger().Error(msg, Fields{"exit_code": code})
+	}
+	os.Exit(code)
+}
+
+// exitErr logs msg with err and exits with code
+func exitErr(code int, msg string, err error) {
+	GetLogger().Error(msg, Fields{"error": err, "exit_code": code})
 	os.Exit(code)
 }
 
@@ -32,12 +46,13 @@ type ErrorCfg struct {
 	Config  map[string]interface{} `json:"config"`
 }
 
+// NewErrCfg returns an ErrorCfg carrying the current settings with secrets redacted (see RedactedSettings)
 func NewErrCfg(msg string, e error) *ErrorCfg {
 
 	err := &ErrorCfg{
 		Message: msg,
 
-		Config: viper.AllSettings(),
+		Config: RedactedSettings(),
 	}
 	if e == nil {
 		err.Err = ""
@@ -66,13 +81,169 @@ func (e *ErrorCfg) Error() string {
 // toPrettyJson encodes an item into a pretty (indented) JSON string
 func (e *ErrorCfg) FailIfErr() {
 	if e.Err != "" {
-		logrus.Fatal(e.String())
+		logFatal(e.Message, Fields{"error": e.Err, "config": e.Config})
 	}
 }
 
 // toPrettyJson encodes an item into a pretty (indented) JSON string
 func (e *ErrorCfg) WarnIfErr() {
 	if e.Err != "" {
-		logrus.Warn(e.String())
+		GetLogger().Warn(e.Message, Fields{"error": e.Err, "config": e.Config})
+	}
+}
+
+// ErrorCode is a machine readable error category, mapped to an http status by HTTPStatus
+type ErrorCode string
+
+const (
+	CodeUnknown            ErrorCode = "unknown"
+	CodeInvalidArgument    ErrorCode = "invalid_argument"
+	CodeUnauthenticated    ErrorCode = "unauthenticated"
+	CodePermissionDenied   ErrorCode = "permission_denied"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeAlreadyExists      ErrorCode = "already_exists"
+	CodeFailedPrecondition ErrorCode = "failed_precondition"
+	CodeResourceExhausted  ErrorCode = "resource_exhausted"
+	CodeCanceled           ErrorCode = "canceled"
+	CodeInternal           ErrorCode = "internal"
+	CodeUnimplemented      ErrorCode = "unimplemented"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeDeadlineExceeded   ErrorCode = "deadline_exceeded"
+)
+
+var codeStatus = map[ErrorCode]int{
+	CodeUnknown:            http.StatusInternalServerError,
+	CodeInvalidArgument:    http.StatusBadRequest,
+	CodeUnauthenticated:    http.StatusUnauthorized,
+	CodePermissionDenied:   http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeAlreadyExists:      http.StatusConflict,
+	CodeFailedPrecondition: http.StatusPreconditionFailed,
+	CodeResourceExhausted:  http.StatusTooManyRequests,
+	CodeCanceled:           499,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnimplemented:      http.StatusNotImplemented,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeDeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+// HTTPStatus returns the http status code for c, 500 for unknown codes
+func (c ErrorCode) HTTPStatus() int {
+	if status, ok := codeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code, an optional cause, key value details and the stack where it was created.
+// It works with errors.Is, which matches another *Error with the same code, and errors.As. Format it with %+v to
+// include the stack.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details map[string]interface{}
+	Cause   error
+	stack   []uintptr
+}
+
+// NewError returns an Error with code and message, capturing the caller's stack
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message, stack: callers()}
+}
+
+// Errorf is NewError with a formatted message
+func Errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), stack: callers()}
+}
+
+// WrapError returns an Error with code and message caused by err, which must not be nil
+func WrapError(err error, code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message, Cause: err, stack: callers()}
+}
+
+func callers() []uintptr {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	return pcs[:n]
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the cause of e
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is an *Error with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail sets a detail on e and returns it
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// HTTPStatus returns the http status code for the code of e
+func (e *Error) HTTPStatus() int {
+	return e.Code.HTTPStatus()
+}
+
+// StackTrace returns the stack captured when e was created, one "function file:line" entry per frame, or nil for an
+// Error built as a struct literal
+func (e *Error) StackTrace() []string {
+	if len(e.stack) == 0 {
+		return nil
+	}
+	var out []string
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		f, more := frames.Next()
+		out = append(out, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// Format implements fmt.Formatter, printing the stack after the message for %+v
+func (e *Error) Format(s fmt.State, verb rune) {
+	io.WriteString(s, e.Error())
+	if verb == 'v' && s.Flag('+') {
+		for _, frame := range e.StackTrace() {
+			io.WriteString(s, "\n\t"+frame)
+		}
+	}
+}
+
+// ErrorCodeOf returns the code of the first *Error in the chain of err. Context cancellation and deadline errors map
+// to CodeCanceled and CodeDeadlineExceeded, and anything else to CodeUnknown.
+func ErrorCodeOf(err error) ErrorCode {
+	var e *Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
 	}
+	return CodeUnknown
 }
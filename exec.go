@@ -0,0 +1,252 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/sprig"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// ExecStdIOContentType is the Accept/Content-Type of responses framed with NewStdIOWriter. Decode them with IOStdCopy.
+	ExecStdIOContentType = "application/vnd.util.stdio-stream"
+	// ExecExitCodeTrailer is the trailer holding the exit code of streamed commands
+	ExecExitCodeTrailer = "Exec-Exit-Code"
+	// ExecCommandParam is the request parameter naming the command to run
+	ExecCommandParam = "command"
+)
+
+// ExecCommand is a command that may be run by NewExecHandler.
+// Args are go templates rendered against the request parameters, each producing exactly one argument, ie. "--branch={{.branch}}".
+// Only parameters listed in Params are accepted and each must fully match its regular expression.
+type ExecCommand struct {
+	Name    string
+	Dir     string
+	Env     []string
+	Args    []string
+	Params  map[string]string
+	Timeout time.Duration
+}
+
+// ExecConfig configures NewExecHandler. Commands is the allowlist of commands keyed by the name clients request them by.
+type ExecConfig struct {
+	Commands map[string]*ExecCommand
+	// Timeout applies to commands without their own timeout. Defaults to one minute.
+	Timeout time.Duration
+}
+
+// ExecResult is the json response of NewExecHandler for clients that do not request a stream
+type ExecResult struct {
+	Command  string        `json:"command"`
+	Args     []string      `json:"args"`
+	ExitCode int           `json:"exit_code"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type execEntry struct {
+	cmd    *ExecCommand
+	args   []*template.Template
+	params map[string]*regexp.Regexp
+}
+
+// NewExecHandler returns a handler that runs allowlisted commands named by the "command" request parameter.
+// Only POST requests are accepted, so commands cannot be triggered by links or cross-site GETs.
+// Output is returned as an ExecResult unless the client accepts text/event-stream, in which case stdout and stderr lines
+// are sent as "stdout" and "stderr" events followed by an "exit" event, or ExecStdIOContentType, in which case output is
+// framed with NewStdIOWriter and the exit code is sent in the ExecExitCodeTrailer trailer.
+func NewExecHandler(cfg ExecConfig) (http.Handler, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+	entries := make(map[string]*execEntry, len(cfg.Commands))
+	for key, cmd := range cfg.Commands {
+		e := &execEntry{cmd: cmd, params: map[string]*regexp.Regexp{}}
+		for i, a := range cmd.Args {
+			t, err := template.New(fmt.Sprintf("%s[%d]", key, i)).Option("missingkey=zero").Funcs(sprig.TxtFuncMap()).Parse(a)
+			if err != nil {
+				return nil, fmt.Errorf("exec: command %s: invalid arg template %q: %s", key, a, err)
+			}
+			e.args = append(e.args, t)
+		}
+		for p, expr := range cmd.Params {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("exec: command %s: invalid pattern for param %s: %s", key, p, err)
+			}
+			e.params[p] = re
+		}
+		entries[key] = e
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, fmt.Sprintf("exec: method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := r.Form.Get(ExecCommandParam)
+		e, ok := entries[key]
+		if !ok {
+			http.Error(w, fmt.Sprintf("exec: command %q is not allowed", key), http.StatusForbidden)
+			return
+		}
+		args, err := e.render(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		timeout := e.cmd.Timeout
+		if timeout <= 0 {
+			timeout = cfg.Timeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "text/event-stream"):
+			execSSE(ctx, w, e.cmd, args)
+		case strings.Contains(accept, ExecStdIOContentType):
+			execStdIO(ctx, w, e.cmd, args)
+		default:
+			execBuffered(ctx, w, e.cmd, args)
+		}
+	}), nil
+}
+
+func (e *execEntry) render(r *http.Request) ([]string, error) {
+	data := map[string]string{}
+	for k, vals := range r.Form {
+		if k == ExecCommandParam {
+			continue
+		}
+		re, ok := e.params[k]
+		if !ok {
+			return nil, fmt.Errorf("exec: unknown param %q", k)
+		}
+		if len(vals) != 1 || !re.MatchString(vals[0]) {
+			return nil, fmt.Errorf("exec: invalid value for param %q", k)
+		}
+		data[k] = vals[0]
+	}
+	args := make([]string, len(e.args))
+	for i, t := range e.args {
+		buf := bytes.NewBuffer(nil)
+		if err := t.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("exec: failed to render arg %d: %s", i, err)
+		}
+		args[i] = buf.String()
+	}
+	return args, nil
+}
+
+func execBuffered(ctx context.Context, w http.ResponseWriter, cmd *ExecCommand, args []string) {
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	code, err := ExecStream(ctx, cmd.Name, cmd.Dir, cmd.Env, &stdout, &stderr, args...)
+	res := &ExecResult{
+		Command:  cmd.Name,
+		Args:     args,
+		ExitCode: code,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(ToPrettyJson(res))
+}
+
+func execStdIO(ctx context.Context, w http.ResponseWriter, cmd *ExecCommand, args []string) {
+	w.Header().Set("Content-Type", ExecStdIOContentType)
+	w.Header().Set("Trailer", ExecExitCodeTrailer)
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	code, err := ExecStream(ctx, cmd.Name, cmd.Dir, cmd.Env, NewStdIOWriter(fw, Stdout), NewStdIOWriter(fw, Stderr), args...)
+	if err != nil {
+		NewStdIOWriter(fw, Systemerr).Write([]byte(err.Error()))
+	}
+	w.Header().Set(ExecExitCodeTrailer, strconv.Itoa(code))
+}
+
+func execSSE(ctx context.Context, w http.ResponseWriter, cmd *ExecCommand, args []string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	stdout := &sseLineWriter{event: "stdout", w: fw}
+	stderr := &sseLineWriter{event: "stderr", w: fw}
+	code, err := ExecStream(ctx, cmd.Name, cmd.Dir, cmd.Env, stdout, stderr, args...)
+	stdout.Close()
+	stderr.Close()
+	exit := map[string]interface{}{"exit_code": code}
+	if err != nil {
+		exit["error"] = err.Error()
+	}
+	bits, _ := json.Marshal(exit)
+	fmt.Fprintf(fw, "event: exit\ndata: %s\n\n", bits)
+}
+
+// flushWriter serializes writes from concurrent streams and flushes each one to the client
+type flushWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// sseLineWriter emits each complete line written to it as a server-sent event
+type sseLineWriter struct {
+	event string
+	w     io.Writer
+	buf   []byte
+}
+
+func (s *sseLineWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", s.event, bytes.TrimSuffix(s.buf[:i], []byte("\r"))); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+}
+
+// Close emits any trailing partial line
+func (s *sseLineWriter) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", s.event, s.buf)
+	s.buf = nil
+	return err
+}
@@ -0,0 +1,214 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NewFormRequest returns a request with vals encoded as an application/x-www-form-urlencoded body
+func NewFormRequest(ctx context.Context, method, url string, vals url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(vals.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req.WithContext(ctx), nil
+}
+
+// MultipartFile is a file uploaded by NewMultipartRequest. Name defaults to the base name of Path and ContentType
+// is detected from its extension when empty.
+type MultipartFile struct {
+	Field       string
+	Path        string
+	Name        string
+	ContentType string
+}
+
+// MultipartForm is the body of a multipart/form-data request. Files are validated before the request is built, with
+// Validate if set and otherwise by requiring them to be regular files, in the same way as FileFlag. Every invalid file
+// is reported together in a MultiError.
+// Progress, if set, is called as the body is sent with the bytes written so far and the total body size.
+type MultipartForm struct {
+	Fields   url.Values
+	Files    []MultipartFile
+	Validate func(os.FileInfo, error) error
+	Progress func(written, total int64)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// NewMultipartRequest returns a request with form encoded as a multipart/form-data body. Files are streamed from
+// disk as the body is read rather than buffered, and the request's GetBody is set so it can be retried. Nothing is
+// opened until the body is first read, so a request that is never sent holds no resources.
+func NewMultipartRequest(ctx context.Context, method, url string, form *MultipartForm) (*http.Request, error) {
+	validate := form.Validate
+	if validate == nil {
+		validate = func(info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", info.Name())
+			}
+			return nil
+		}
+	}
+	files := make([]MultipartFile, len(form.Files))
+	var fileSize int64
+	errs := &MultiError{}
+	for i, f := range form.Files {
+		flag := &FileFlag{Validate: validate}
+		if err := flag.Set(f.Path); err != nil {
+			errs.Append(fmt.Errorf("multipart: invalid file %s: %s", f.Path, err))
+			continue
+		}
+		info, err := fs.Stat(f.Path)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		fileSize += info.Size()
+		if f.Name == "" {
+			f.Name = filepath.Base(f.Path)
+		}
+		if f.ContentType == "" {
+			if f.ContentType = mime.TypeByExtension(filepath.Ext(f.Path)); f.ContentType == "" {
+				f.ContentType = "application/octet-stream"
+			}
+		}
+		files[i] = f
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	// measure everything but the file contents by writing the form with empty files
+	counter := &countingWriter{w: ioutil.Discard}
+	if err := writeMultipart(counter, boundary, form.Fields, files, nil); err != nil {
+		return nil, err
+	}
+	total := counter.n + fileSize
+
+	body := func() io.ReadCloser {
+		return &lazyPipe{write: func(w io.Writer) error {
+			cw := &countingWriter{w: w, total: total, progress: form.Progress}
+			return writeMultipart(cw, boundary, form.Fields, files, openFile)
+		}}
+	}
+	req, err := http.NewRequest(method, url, body())
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = total
+	req.GetBody = func() (io.ReadCloser, error) { return body(), nil }
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	return req.WithContext(ctx), nil
+}
+
+// lazyPipe is a body produced by write in a goroutine that is only started on the first Read, so a request that is
+// never sent leaks neither the goroutine nor its open files. Close stops the writer.
+type lazyPipe struct {
+	write func(io.Writer) error
+	once  sync.Once
+	pr    *io.PipeReader
+}
+
+func (l *lazyPipe) start() {
+	pr, pw := io.Pipe()
+	l.pr = pr
+	go func() {
+		pw.CloseWithError(l.write(pw))
+	}()
+}
+
+func (l *lazyPipe) Read(p []byte) (int, error) {
+	l.once.Do(l.start)
+	if l.pr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return l.pr.Read(p)
+}
+
+func (l *lazyPipe) Close() error {
+	l.once.Do(func() {})
+	if l.pr == nil {
+		return nil
+	}
+	return l.pr.Close()
+}
+
+func openFile(path string) (io.ReadCloser, error) {
+	return fs.Open(path)
+}
+
+// writeMultipart writes the form to w. If open is nil, file parts are written without content.
+func writeMultipart(w io.Writer, boundary string, fields url.Values, files []MultipartFile, open func(string) (io.ReadCloser, error)) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range fields[k] {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(f.Field), quoteEscaper.Replace(f.Name)))
+		h.Set("Content-Type", f.ContentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if open == nil {
+			continue
+		}
+		src, err := open(f.Path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// countingWriter counts the bytes written through it, reporting progress if set
+type countingWriter struct {
+	w        io.Writer
+	n        int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if c.progress != nil {
+		c.progress(c.n, c.total)
+	}
+	return n, err
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewMultipartRequestStartsLazily(t *testing.T) {
+	form := &MultipartForm{Files: []MultipartFile{{Field: "file", Path: "form_test.go"}}}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		if _, err := NewMultipartRequest(context.Background(), http.MethodPost, "http://example.com", form); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("expected unsent requests to start no goroutines, %d were left running", after-before)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := r.FormFile("file"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	req, err := NewMultipartRequest(context.Background(), http.MethodPost, srv.URL, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the file to be uploaded, got %s", resp.Status)
+	}
+}
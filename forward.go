@@ -0,0 +1,255 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HopHeaders are the hop-by-hop headers defined in RFC 7230 section 6.1, which are meaningful for a single connection
+// and must not be forwarded by proxies
+var HopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders removes the hop-by-hop headers from h, including any listed in its Connection header.
+// "Te: trailers" is kept since gRPC and other trailer based protocols depend on it end to end.
+func RemoveHopHeaders(h http.Header) {
+	trailers := HeaderContainsToken(h, "Te", "trailers")
+	RemoveHeaders(h, "Connection")
+	for _, k := range HopHeaders {
+		h.Del(k)
+	}
+	if trailers {
+		h.Set("Te", "trailers")
+	}
+}
+
+// TrustedProxies is a list of networks whose forwarding headers are believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses CIDRs, ie. 10.0.0.0/8, or single ip addresses into TrustedProxies
+func ParseTrustedProxies(cidrs ...string) (TrustedProxies, error) {
+	var out TrustedProxies
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %s", c, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Contains reports whether ip, which may carry a port, belongs to a trusted network
+func (t TrustedProxies) Contains(ip string) bool {
+	parsed := net.ParseIP(stripPort(ip))
+	if parsed == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent r. Forwarded addresses, from X-Forwarded-For or else the
+// Forwarded header, are walked from the nearest hop back and the first one that is not a trusted proxy is returned,
+// so clients cannot spoof their address by sending the headers themselves.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	remote := stripPort(r.RemoteAddr)
+	if !t.Contains(remote) {
+		return remote
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !t.Contains(hops[i]) || i == 0 {
+			return stripPort(hops[i])
+		}
+	}
+	return remote
+}
+
+// HeaderPolicy controls the headers a proxy forwards upstream. Hop-by-hop headers are always removed, except that
+// Connection: Upgrade and Upgrade are kept on upgrade requests, ie. websockets.
+// When Deny is set matching headers are removed, and when Allow is set only matching headers are kept. Entries are
+// header names or prefixes ending in *, ie. X-Custom-*.
+// XForwarded sets X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host and Forwarded sets the RFC 7239 Forwarded
+// header. Existing forwarding headers are appended to only if the request came from one of TrustedProxies;
+// otherwise they are replaced.
+type HeaderPolicy struct {
+	TrustedProxies TrustedProxies
+	Allow          []string
+	Deny           []string
+	XForwarded     bool
+	Forwarded      bool
+}
+
+// Apply rewrites the headers of r, a request about to be forwarded, according to the policy
+func (p *HeaderPolicy) Apply(r *http.Request) {
+	p.apply(r, true)
+}
+
+// Director wraps an httputil.ReverseProxy director, ie. one from ProxyRequestFunc, applying the policy first.
+// next may be nil. The client address is left for the ReverseProxy to append to X-Forwarded-For, as it always does.
+func (p *HeaderPolicy) Director(next func(*http.Request)) func(*http.Request) {
+	return func(r *http.Request) {
+		p.apply(r, false)
+		if next != nil {
+			next(r)
+		}
+	}
+}
+
+func (p *HeaderPolicy) apply(r *http.Request, appendClient bool) {
+	trusted := p.TrustedProxies.Contains(r.RemoteAddr)
+	hops := forwardedFor(r.Header)
+	proto, host := r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Host")
+	forwarded := r.Header.Values("Forwarded")
+	upgrade := ""
+	if HeaderContainsToken(r.Header, "Connection", "upgrade") {
+		upgrade = r.Header.Get("Upgrade")
+	}
+
+	RemoveHopHeaders(r.Header)
+	for k := range r.Header {
+		if (len(p.Allow) > 0 && !matchHeader(p.Allow, k)) || matchHeader(p.Deny, k) {
+			r.Header.Del(k)
+		}
+	}
+	if upgrade != "" {
+		// httputil.ReverseProxy and NewWebsocketProxy read these after the director to proxy the upgrade
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", upgrade)
+	}
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Del("X-Forwarded-Proto")
+	r.Header.Del("X-Forwarded-Host")
+	r.Header.Del("Forwarded")
+	if !trusted {
+		hops, proto, host, forwarded = nil, "", "", nil
+	}
+
+	client := stripPort(r.RemoteAddr)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p.XForwarded {
+		xff := hops
+		if appendClient {
+			xff = append(xff, client)
+		}
+		if len(xff) > 0 {
+			r.Header.Set("X-Forwarded-For", strings.Join(xff, ", "))
+		}
+		if proto == "" {
+			proto = scheme
+		}
+		if host == "" {
+			host = r.Host
+		}
+		r.Header.Set("X-Forwarded-Proto", proto)
+		r.Header.Set("X-Forwarded-Host", host)
+	}
+	if p.Forwarded {
+		elem := fmt.Sprintf("for=%s;proto=%s", forwardedNode(client), scheme)
+		if r.Host != "" {
+			elem += ";host=" + quoteForwarded(r.Host)
+		}
+		r.Header.Set("Forwarded", strings.Join(append(forwarded, elem), ", "))
+	}
+}
+
+func matchHeader(patterns []string, key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(key, http.CanonicalHeaderKey(strings.TrimSuffix(p, "*"))) {
+				return true
+			}
+		} else if http.CanonicalHeaderKey(p) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the forwarded client addresses from X-Forwarded-For, or the for= parameters of Forwarded
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				hops = append(hops, ip)
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hops = append(hops, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port, and the brackets of Forwarded style ipv6 nodes, from an address
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// forwardedNode formats ip as a Forwarded node, quoting and bracketing ipv6 addresses
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !httpTokenChar(c) {
+			return `"` + quoteEscaper.Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func httpTokenChar(c rune) bool {
+	return c < 127 && c > 32 && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, c)
+}
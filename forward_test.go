@@ -0,0 +1,34 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderPolicyKeepsUpgrade(t *testing.T) {
+	p := &HeaderPolicy{Allow: []string{"Authorization"}, XForwarded: true}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	p.Director(nil)(r)
+
+	if !IsWebsocketRequest(r) {
+		t.Fatalf("expected the upgrade headers to be kept, got %v", r.Header)
+	}
+	if r.Header.Get("Keep-Alive") != "" {
+		t.Fatalf("expected other hop-by-hop headers to be removed, got %v", r.Header)
+	}
+}
+
+func TestHeaderPolicyRemovesHopHeaders(t *testing.T) {
+	p := &HeaderPolicy{}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("Connection", "close")
+	r.Header.Set("Upgrade", "websocket")
+	p.Apply(r)
+	if r.Header.Get("Connection") != "" || r.Header.Get("Upgrade") != "" {
+		t.Fatalf("expected hop-by-hop headers to be removed, got %v", r.Header)
+	}
+}
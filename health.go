@@ -0,0 +1,252 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CheckFunc reports whether a dependency is healthy. It should return promptly once ctx is done.
+type CheckFunc func(ctx context.Context) error
+
+// CheckKind selects which probes a check contributes to. Kinds may be combined, ie. Liveness|Readiness.
+type CheckKind int
+
+const (
+	Liveness CheckKind = 1 << iota
+	Readiness
+)
+
+// CheckOptions configures a registered check. Timeout defaults to one second and Interval, the time a result is
+// cached for, to ten seconds. Kind defaults to Readiness.
+type CheckOptions struct {
+	Kind     CheckKind
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// CheckResult is the cached outcome of a check
+type CheckResult struct {
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// HealthReport is the json body served by the health handlers
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks"`
+}
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+type healthCheck struct {
+	name string
+	fn   CheckFunc
+	opts CheckOptions
+
+	mu     sync.Mutex
+	result *CheckResult
+}
+
+// Health is a registry of health checks serving kubernetes style /healthz and /readyz handlers
+type Health struct {
+	mu     sync.RWMutex
+	checks []*healthCheck
+}
+
+// NewHealth returns an empty Health registry
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Register adds a named check to the registry
+func (h *Health) Register(name string, fn CheckFunc, opts CheckOptions) {
+	if opts.Kind == 0 {
+		opts.Kind = Readiness
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, &healthCheck{name: name, fn: fn, opts: opts})
+}
+
+// Start refreshes every check on its interval in the background until ctx is done, so handlers never wait on a check
+func (h *Health) Start(ctx context.Context) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, c := range h.checks {
+		go func(c *healthCheck) {
+			t := time.NewTicker(c.opts.Interval)
+			defer t.Stop()
+			for {
+				c.run(ctx)
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+			}
+		}(c)
+	}
+}
+
+// run runs the check and caches its result, unless it failed because ctx, ie. that of a probe request, was canceled
+// or timed out, which says nothing about the health of the check
+func (c *healthCheck) run(parent context.Context) *CheckResult {
+	ctx, cancel := context.WithTimeout(parent, c.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	res := &CheckResult{Status: HealthStatusOK, CheckedAt: start}
+	if err := c.fn(ctx); err != nil {
+		res.Status = HealthStatusFail
+		res.Error = err.Error()
+	}
+	res.Duration = time.Since(start)
+	if res.Status != HealthStatusOK && parent.Err() != nil {
+		return res
+	}
+	c.mu.Lock()
+	c.result = res
+	c.mu.Unlock()
+	return res
+}
+
+func (c *healthCheck) cached(ctx context.Context) *CheckResult {
+	c.mu.Lock()
+	res := c.result
+	c.mu.Unlock()
+	if res != nil && time.Since(res.CheckedAt) < c.opts.Interval {
+		return res
+	}
+	return c.run(ctx)
+}
+
+// Report returns the results of every check of the given kind, running checks whose cached result has expired
+func (h *Health) Report(ctx context.Context, kind CheckKind) *HealthReport {
+	h.mu.RLock()
+	var checks []*healthCheck
+	for _, c := range h.checks {
+		if c.opts.Kind&kind != 0 {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]*CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.cached(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := &HealthReport{Status: HealthStatusOK, Checks: make(map[string]*CheckResult, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != HealthStatusOK {
+			report.Status = HealthStatusFail
+		}
+	}
+	return report
+}
+
+// Handler serves a report of the given kind, responding 503 Service Unavailable if any check fails
+func (h *Health) Handler(kind CheckKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.Report(r.Context(), kind)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != HealthStatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(ToPrettyJson(report))
+	}
+}
+
+// Healthz serves the liveness checks
+func (h *Health) Healthz() http.HandlerFunc {
+	return h.Handler(Liveness)
+}
+
+// Readyz serves the readiness checks
+func (h *Health) Readyz() http.HandlerFunc {
+	return h.Handler(Readiness)
+}
+
+// Routes registers Healthz and Readyz on mux at /healthz and /readyz
+func (h *Health) Routes(mux *http.ServeMux) {
+	mux.Handle("/healthz", h.Healthz())
+	mux.Handle("/readyz", h.Readyz())
+}
+
+// TCPCheck succeeds if a tcp connection can be opened to addr
+func TCPCheck(addr string) CheckFunc {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPGetCheck succeeds if a GET request to url responds with status, or any 2xx status if status is 0
+func HTTPGetCheck(url string, status int) CheckFunc {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if status == 0 && resp.StatusCode >= 200 && resp.StatusCode < 300 || resp.StatusCode == status {
+			return nil
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// ExecCheck succeeds if the command exits with status 0
+func ExecCheck(name, dir string, args ...string) CheckFunc {
+	return func(ctx context.Context) error {
+		out, err := Exec(ctx, name, dir, nil, args...)
+		if err != nil && len(out) > 0 {
+			return fmt.Errorf("%s: %s", err, Trunc(256, string(out)))
+		}
+		return err
+	}
+}
+
+// FileCheck succeeds if path exists
+func FileCheck(path string) CheckFunc {
+	return func(ctx context.Context) error {
+		ok, err := fs.Exists(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s does not exist", path)
+		}
+		return nil
+	}
+}
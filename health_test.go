@@ -0,0 +1,28 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHealthDoesNotCacheCanceledProbes(t *testing.T) {
+	h := NewHealth()
+	h.Register("db", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	}, CheckOptions{Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := h.Report(ctx, Readiness); r.Status != HealthStatusFail {
+		t.Fatalf("expected the canceled probe to fail, got %s", r.Status)
+	}
+	if r := h.Report(context.Background(), Readiness); r.Status != HealthStatusOK {
+		t.Fatalf("expected the failure of a canceled probe not to be cached, got %s", r.Status)
+	}
+}
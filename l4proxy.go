@@ -0,0 +1,359 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// L4ProxyOptions configures an L4Proxy.
+// Network is tcp or udp and defaults to tcp. Connections are spread over Backends round robin, skipping backends that
+// fail a Ping every HealthInterval (tcp only, 5 seconds by default). MaxConns limits concurrent connections, or udp
+// sessions, and is unlimited when 0. IdleTimeout closes connections without traffic; udp sessions always expire and
+// default to a minute. If TLS is set, tcp connections are terminated with that certificate before being forwarded.
+type L4ProxyOptions struct {
+	Network        string
+	Listen         string
+	Backends       []string
+	MaxConns       int
+	IdleTimeout    time.Duration
+	DialTimeout    time.Duration
+	HealthInterval time.Duration
+	TLS            *Certificate
+}
+
+// L4Stats are the counters of an L4Proxy. BytesIn is sent from clients to backends and BytesOut the other way.
+type L4Stats struct {
+	Active   int64           `json:"active"`
+	Total    int64           `json:"total"`
+	Rejected int64           `json:"rejected"`
+	Failed   int64           `json:"failed"`
+	BytesIn  int64           `json:"bytes_in"`
+	BytesOut int64           `json:"bytes_out"`
+	Backends map[string]bool `json:"backends"`
+}
+
+// L4Proxy forwards tcp connections or udp datagrams from a local address to a set of backends
+type L4Proxy struct {
+	opts     L4ProxyOptions
+	tlsConf  *tls.Config
+	slots    chan struct{}
+	next     uint64
+	healthy  []int32
+	active   int64
+	total    int64
+	rejected int64
+	failed   int64
+	bytesIn  int64
+	bytesOut int64
+
+	mu       sync.Mutex
+	listener net.Listener
+	packet   net.PacketConn
+}
+
+// NewL4Proxy validates opts and returns an L4Proxy. Call Run to start it.
+func NewL4Proxy(opts L4ProxyOptions) (*L4Proxy, error) {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Network != "tcp" && opts.Network != "udp" {
+		return nil, fmt.Errorf("l4 proxy: unsupported network %q", opts.Network)
+	}
+	if len(opts.Backends) == 0 {
+		return nil, fmt.Errorf("l4 proxy: no backends for %s", opts.Listen)
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 5 * time.Second
+	}
+	if opts.Network == "udp" && opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = time.Minute
+	}
+	p := &L4Proxy{opts: opts, healthy: make([]int32, len(opts.Backends))}
+	for i := range p.healthy {
+		p.healthy[i] = 1
+	}
+	if opts.MaxConns > 0 {
+		p.slots = make(chan struct{}, opts.MaxConns)
+	}
+	if opts.TLS != nil {
+		if opts.Network != "tcp" {
+			return nil, fmt.Errorf("l4 proxy: tls is only supported for tcp")
+		}
+		cert, err := opts.TLS.TLSCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("l4 proxy: invalid certificate: %s", err)
+		}
+		p.tlsConf = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	return p, nil
+}
+
+// Listen opens the listening socket. Run calls it if it has not been called, so it is only needed to learn Addr
+// before running, ie. when listening on port 0.
+func (p *L4Proxy) Listen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener != nil || p.packet != nil {
+		return nil
+	}
+	if p.opts.Network == "udp" {
+		pc, err := net.ListenPacket("udp", p.opts.Listen)
+		if err != nil {
+			return err
+		}
+		p.packet = pc
+		return nil
+	}
+	ln, err := net.Listen("tcp", p.opts.Listen)
+	if err != nil {
+		return err
+	}
+	if p.tlsConf != nil {
+		ln = tls.NewListener(ln, p.tlsConf)
+	}
+	p.listener = ln
+	return nil
+}
+
+// Addr returns the address the proxy listens on, or nil before Listen
+func (p *L4Proxy) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener != nil {
+		return p.listener.Addr()
+	}
+	if p.packet != nil {
+		return p.packet.LocalAddr()
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the proxy counters and backend health
+func (p *L4Proxy) Stats() L4Stats {
+	s := L4Stats{
+		Active:   atomic.LoadInt64(&p.active),
+		Total:    atomic.LoadInt64(&p.total),
+		Rejected: atomic.LoadInt64(&p.rejected),
+		Failed:   atomic.LoadInt64(&p.failed),
+		BytesIn:  atomic.LoadInt64(&p.bytesIn),
+		BytesOut: atomic.LoadInt64(&p.bytesOut),
+		Backends: map[string]bool{},
+	}
+	for i, b := range p.opts.Backends {
+		s.Backends[b] = atomic.LoadInt32(&p.healthy[i]) == 1
+	}
+	return s
+}
+
+// Run forwards traffic until ctx is done. It can be added to a Lifecycle as a Worker.
+func (p *L4Proxy) Run(ctx context.Context) error {
+	if err := p.Listen(); err != nil {
+		return err
+	}
+	if p.opts.Network == "udp" {
+		return p.runUDP(ctx)
+	}
+	go p.checkHealth(ctx)
+	go func() {
+		<-ctx.Done()
+		p.listener.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !p.acquire() {
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer p.release()
+			p.handleTCP(ctx, conn)
+		}()
+	}
+}
+
+func (p *L4Proxy) acquire() bool {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			atomic.AddInt64(&p.rejected, 1)
+			return false
+		}
+	}
+	atomic.AddInt64(&p.total, 1)
+	atomic.AddInt64(&p.active, 1)
+	return true
+}
+
+func (p *L4Proxy) release() {
+	atomic.AddInt64(&p.active, -1)
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func (p *L4Proxy) handleTCP(ctx context.Context, conn net.Conn) {
+	backend, err := p.dial(ctx)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			backend.Close()
+		case <-stop:
+		}
+	}()
+	spliceConns(conn, backend, p.opts.IdleTimeout, nil, nil, &p.bytesIn, &p.bytesOut)
+}
+
+// dial connects to the next healthy backend, trying each backend once before giving up
+func (p *L4Proxy) dial(ctx context.Context) (net.Conn, error) {
+	n := len(p.opts.Backends)
+	start := int(atomic.AddUint64(&p.next, 1)-1) % n
+	var lastErr error
+	// healthy backends first, then the rest in case the health checks are stale
+	for _, wantHealthy := range []bool{true, false} {
+		for i := 0; i < n; i++ {
+			idx := (start + i) % n
+			if (atomic.LoadInt32(&p.healthy[idx]) == 1) != wantHealthy {
+				continue
+			}
+			d := &net.Dialer{Timeout: p.opts.DialTimeout}
+			conn, err := d.DialContext(ctx, p.opts.Network, p.opts.Backends[idx])
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if p.opts.Network == "tcp" {
+				atomic.StoreInt32(&p.healthy[idx], 0)
+			}
+		}
+	}
+	atomic.AddInt64(&p.failed, 1)
+	return nil, fmt.Errorf("l4 proxy: no backend available: %s", lastErr)
+}
+
+func (p *L4Proxy) checkHealth(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		for i, b := range p.opts.Backends {
+			var ok int32
+			if PingTimeout(b, p.opts.DialTimeout) == nil {
+				ok = 1
+			}
+			atomic.StoreInt32(&p.healthy[i], ok)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type udpSession struct {
+	backend net.Conn
+}
+
+func (p *L4Proxy) runUDP(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		p.packet.Close()
+	}()
+	var (
+		mu       sync.Mutex
+		sessions = map[string]*udpSession{}
+		wg       sync.WaitGroup
+	)
+	defer wg.Wait()
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := p.packet.ReadFrom(buf)
+		if err != nil {
+			mu.Lock()
+			for _, s := range sessions {
+				s.backend.Close()
+			}
+			mu.Unlock()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		key := addr.String()
+		mu.Lock()
+		s, ok := sessions[key]
+		if !ok {
+			if !p.acquire() {
+				mu.Unlock()
+				continue
+			}
+			backend, err := p.dial(ctx)
+			if err != nil {
+				p.release()
+				mu.Unlock()
+				continue
+			}
+			s = &udpSession{backend: backend}
+			sessions[key] = s
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer p.release()
+				p.relayUDP(s.backend, addr)
+				mu.Lock()
+				delete(sessions, key)
+				mu.Unlock()
+			}()
+		}
+		mu.Unlock()
+		s.backend.SetDeadline(time.Now().Add(p.opts.IdleTimeout))
+		if _, err := s.backend.Write(buf[:n]); err == nil {
+			atomic.AddInt64(&p.bytesIn, int64(n))
+		}
+	}
+}
+
+// relayUDP sends replies from a backend to the client until the session goes idle
+func (p *L4Proxy) relayUDP(backend net.Conn, client net.Addr) {
+	defer backend.Close()
+	buf := make([]byte, 64*1024)
+	for {
+		backend.SetReadDeadline(time.Now().Add(p.opts.IdleTimeout))
+		n, err := backend.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := p.packet.WriteTo(buf[:n], client); err == nil {
+			atomic.AddInt64(&p.bytesOut, int64(n))
+		}
+	}
+}
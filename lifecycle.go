@@ -0,0 +1,163 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ShutdownHook is run by a Lifecycle when it shuts down. The context carries the shutdown deadline.
+type ShutdownHook func(ctx context.Context) error
+
+// Worker is a background task run by a Lifecycle until its context is cancelled
+type Worker func(ctx context.Context) error
+
+type namedWorker struct {
+	name string
+	fn   Worker
+}
+
+// Lifecycle runs http servers and background workers under a single context. It shuts everything down when
+// its context is cancelled, a shutdown signal is received, a server fails or a worker returns an error.
+// In-flight requests are drained until ShutdownTimeout passes, then shutdown hooks are run in reverse order.
+type Lifecycle struct {
+	ShutdownTimeout time.Duration
+	Signals         []os.Signal
+	servers         []*http.Server
+	workers         []namedWorker
+	hooks           []ShutdownHook
+}
+
+// NewLifecycle returns a Lifecycle that shuts down on SIGINT or SIGTERM, allowing shutdownTimeout for draining
+func NewLifecycle(shutdownTimeout time.Duration) *Lifecycle {
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+	return &Lifecycle{
+		ShutdownTimeout: shutdownTimeout,
+		Signals:         []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+}
+
+// AddServer registers a server. Servers with a TLSConfig are started with ListenAndServeTLS, so their certificates
+// must be set in the TLSConfig.
+func (l *Lifecycle) AddServer(srv *http.Server) {
+	l.servers = append(l.servers, srv)
+}
+
+// AddWorker registers a background worker
+func (l *Lifecycle) AddWorker(name string, fn Worker) {
+	l.workers = append(l.workers, namedWorker{name: name, fn: fn})
+}
+
+// OnShutdown registers a hook to run after servers and workers have stopped. Hooks run in reverse order of registration.
+func (l *Lifecycle) OnShutdown(hook ShutdownHook) {
+	l.hooks = append(l.hooks, hook)
+}
+
+// Run starts every server and worker and blocks until shutdown has completed, returning every error encountered
+// by servers, workers and hooks
+func (l *Lifecycle) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if len(l.Signals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, l.Signals...)
+		defer signal.Stop(signals)
+		go func() {
+			select {
+			case sig := <-signals:
+				LoggerFromContext(ctx).Info("shutting down", Fields{"signal": sig.String()})
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	var (
+		mu   sync.Mutex
+		errs MultiError
+	)
+	fail := func(err error) {
+		mu.Lock()
+		errs.Append(err)
+		mu.Unlock()
+		cancel()
+	}
+
+	var servers sync.WaitGroup
+	for _, srv := range l.servers {
+		servers.Add(1)
+		go func(srv *http.Server) {
+			defer servers.Done()
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fail(fmt.Errorf("server %s: %w", srv.Addr, err))
+			}
+		}(srv)
+	}
+	var workers sync.WaitGroup
+	for _, w := range l.workers {
+		workers.Add(1)
+		go func(w namedWorker) {
+			defer workers.Done()
+			if err := w.fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				fail(fmt.Errorf("worker %s: %w", w.name, err))
+			}
+		}(w)
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), l.ShutdownTimeout)
+	defer cancelShutdown()
+
+	for _, srv := range l.servers {
+		servers.Add(1)
+		go func(srv *http.Server) {
+			defer servers.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				fail(fmt.Errorf("server %s: shutdown: %w", srv.Addr, err))
+			}
+		}(srv)
+	}
+	if err := waitCtx(shutdownCtx, &servers); err != nil {
+		fail(fmt.Errorf("servers did not drain: %w", err))
+	}
+	if err := waitCtx(shutdownCtx, &workers); err != nil {
+		fail(fmt.Errorf("workers did not stop: %w", err))
+	}
+	for i := len(l.hooks) - 1; i >= 0; i-- {
+		if err := l.hooks[i](shutdownCtx); err != nil {
+			fail(fmt.Errorf("shutdown hook %d: %w", i, err))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return errs.ErrorOrNil()
+}
+
+func waitCtx(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
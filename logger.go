@@ -0,0 +1,170 @@
+package util
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Fields are structured key value pairs attached to a log entry
+type Fields map[string]interface{}
+
+// Logger is the structured, leveled logger every helper in this package logs through. Replace the default with
+// SetLogger. Multiple Fields are merged, later ones winning.
+type Logger interface {
+	Debug(msg string, fields ...Fields)
+	Info(msg string, fields ...Fields)
+	Warn(msg string, fields ...Fields)
+	Error(msg string, fields ...Fields)
+	With(fields Fields) Logger
+}
+
+// LogLevel is the minimum level a logger created by NewLogger writes
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// LogFormat is the output format of a logger created by NewLogger
+type LogFormat int
+
+const (
+	LogText LogFormat = iota
+	LogJSON
+)
+
+// RequestIDHeader is the header RequestID reads and sets
+const RequestIDHeader = "X-Request-Id"
+
+var (
+	loggerMu      sync.RWMutex
+	defaultLogger = NewLogger(os.Stderr, LogText, LevelInfo)
+)
+
+// SetLogger replaces the logger used by the package
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	defaultLogger = l
+}
+
+// GetLogger returns the logger used by the package, which writes text at info level to stderr unless replaced
+func GetLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return defaultLogger
+}
+
+// NewLogger returns a Logger writing entries at or above level to w as text or json
+func NewLogger(w io.Writer, format LogFormat, level LogLevel) Logger {
+	l := logrus.New()
+	l.SetOutput(w)
+	if format == LogJSON {
+		l.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		l.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	}
+	switch level {
+	case LevelDebug:
+		l.SetLevel(logrus.DebugLevel)
+	case LevelWarn:
+		l.SetLevel(logrus.WarnLevel)
+	case LevelError:
+		l.SetLevel(logrus.ErrorLevel)
+	default:
+		l.SetLevel(logrus.InfoLevel)
+	}
+	return NewLogrusLogger(l)
+}
+
+// NewLogrusLogger adapts an existing logrus logger to Logger
+func NewLogrusLogger(l *logrus.Logger) Logger {
+	return &logrusLogger{entry: logrus.NewEntry(l)}
+}
+
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+func (l *logrusLogger) with(fields []Fields) *logrus.Entry {
+	e := l.entry
+	for _, f := range fields {
+		e = e.WithFields(logrus.Fields(f))
+	}
+	return e
+}
+
+func (l *logrusLogger) Debug(msg string, fields ...Fields) { l.with(fields).Debug(msg) }
+func (l *logrusLogger) Info(msg string, fields ...Fields)  { l.with(fields).Info(msg) }
+func (l *logrusLogger) Warn(msg string, fields ...Fields)  { l.with(fields).Warn(msg) }
+func (l *logrusLogger) Error(msg string, fields ...Fields) { l.with(fields).Error(msg) }
+
+func (l *logrusLogger) With(fields Fields) Logger {
+	return &logrusLogger{entry: l.entry.WithFields(logrus.Fields(fields))}
+}
+
+// logFatal logs msg at error level and exits with status 1
+func logFatal(msg string, fields ...Fields) {
+	GetLogger().Error(msg, fields...)
+	os.Exit(1)
+}
+
+type logFieldsKey struct{}
+
+// WithLogFields returns a context carrying fields, in addition to any it already carries, for LoggerFromContext
+func WithLogFields(ctx context.Context, fields Fields) context.Context {
+	merged := Fields{}
+	if existing, ok := ctx.Value(logFieldsKey{}).(Fields); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, logFieldsKey{}, merged)
+}
+
+// LoggerFromContext returns the package logger with the fields carried by ctx
+func LoggerFromContext(ctx context.Context) Logger {
+	l := GetLogger()
+	if ctx == nil {
+		return l
+	}
+	if fields, ok := ctx.Value(logFieldsKey{}).(Fields); ok && len(fields) > 0 {
+		return l.With(fields)
+	}
+	return l
+}
+
+// RequestID returns middleware giving every request an id, taken from the X-Request-Id header or generated. The id is
+// echoed in the response header and added to the request context as the request_id log field.
+func RequestID() HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" || len(id) > 128 {
+				id = Uuidv4()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(WithLogFields(r.Context(), Fields{"request_id": id})))
+		})
+	}
+}
+
+// RequestIDFromContext returns the request id set by RequestID, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	if fields, ok := ctx.Value(logFieldsKey{}).(Fields); ok {
+		if id, ok := fields["request_id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// MetadataHeaderPrefix marks http headers carrying grpc metadata, in both directions
+	MetadataHeaderPrefix = "Grpc-Metadata-"
+	// MetadataTrailerPrefix marks http headers carrying grpc trailer metadata in responses
+	MetadataTrailerPrefix = "Grpc-Trailer-"
+	// MetadataPermanentPrefix is prepended to permanent http headers forwarded as metadata
+	MetadataPermanentPrefix = "grpcgateway-"
+)
+
+// Metadata is grpc style metadata with lower case keys. It converts directly to and from metadata.MD.
+// Values of keys ending in -bin are binary and are base64 encoded when carried in http headers.
+type Metadata map[string][]string
+
+// Get returns the first value for key, or "" if there is none
+func (md Metadata) Get(key string) string {
+	if v := md[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Append adds values to key
+func (md Metadata) Append(key string, values ...string) {
+	key = strings.ToLower(key)
+	md[key] = append(md[key], values...)
+}
+
+// HeaderMatcher maps a header or metadata key to its name on the other side, returning false to drop it
+type HeaderMatcher func(key string) (string, bool)
+
+// DefaultIncomingHeaderMatcher forwards permanent http headers with the grpcgateway- prefix and headers with the
+// Grpc-Metadata- prefix without it, dropping everything else. This is the grpc-gateway default.
+func DefaultIncomingHeaderMatcher(key string) (string, bool) {
+	return IncomingHeaderMatcher(MetadataHeaderPrefix)(key)
+}
+
+// IncomingHeaderMatcher is DefaultIncomingHeaderMatcher for headers carrying metadata with prefix instead of
+// Grpc-Metadata-
+func IncomingHeaderMatcher(prefix string) HeaderMatcher {
+	prefix = http.CanonicalHeaderKey(prefix)
+	return func(key string) (string, bool) {
+		key = http.CanonicalHeaderKey(key)
+		if IsPermanentHTTPHeader(key) {
+			return MetadataPermanentPrefix + key, true
+		}
+		if strings.HasPrefix(key, prefix) {
+			return key[len(prefix):], true
+		}
+		return "", false
+	}
+}
+
+// MetadataMapper converts between http headers and grpc metadata.
+// IncomingMatcher maps request headers to metadata keys and defaults to IncomingHeaderMatcher with HeaderPrefix, or
+// Grpc-Metadata- if HeaderPrefix is empty.
+// OutgoingMatcher maps metadata keys to response header names, which are then given HeaderPrefix or TrailerPrefix,
+// and defaults to OutgoingHeaderMatcher. Keys reserved by grpc (see IsReservedGrpcHeader) are never mapped.
+type MetadataMapper struct {
+	HeaderPrefix    string
+	TrailerPrefix   string
+	IncomingMatcher HeaderMatcher
+	OutgoingMatcher HeaderMatcher
+}
+
+// NewMetadataMapper returns a MetadataMapper with the grpc-gateway defaults
+func NewMetadataMapper() *MetadataMapper {
+	return &MetadataMapper{
+		HeaderPrefix:    MetadataHeaderPrefix,
+		TrailerPrefix:   MetadataTrailerPrefix,
+		IncomingMatcher: DefaultIncomingHeaderMatcher,
+		OutgoingMatcher: OutgoingHeaderMatcher,
+	}
+}
+
+// FromHeader returns the metadata carried by request headers h. Values of -bin keys are base64 decoded.
+func (m *MetadataMapper) FromHeader(h http.Header) (Metadata, error) {
+	match := m.IncomingMatcher
+	if match == nil {
+		prefix := m.HeaderPrefix
+		if prefix == "" {
+			prefix = MetadataHeaderPrefix
+		}
+		match = IncomingHeaderMatcher(prefix)
+	}
+	md := Metadata{}
+	for k, vals := range h {
+		key, ok := match(k)
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+		if IsReservedGrpcHeader(http.CanonicalHeaderKey(key)) {
+			continue
+		}
+		if !validMetadataKey(key) {
+			return nil, fmt.Errorf("invalid metadata key %q from header %s", key, k)
+		}
+		for _, v := range vals {
+			if strings.HasSuffix(key, "-bin") {
+				bits, err := DecodeBinHeader(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid binary header %s: %s", k, err)
+				}
+				v = string(bits)
+			}
+			md[key] = append(md[key], v)
+		}
+	}
+	return md, nil
+}
+
+// ToHeader adds md to response headers h with HeaderPrefix. Values of -bin keys are base64 encoded.
+func (m *MetadataMapper) ToHeader(md Metadata, h http.Header) {
+	m.toHeader(md, h, m.HeaderPrefix)
+}
+
+// TrailerToHeader adds trailer metadata md to h with TrailerPrefix, declaring each in the Trailer header when
+// declare is true so it can be sent as an http trailer
+func (m *MetadataMapper) TrailerToHeader(md Metadata, h http.Header, declare bool) {
+	for _, k := range m.toHeader(md, h, m.TrailerPrefix) {
+		if declare {
+			h.Add("Trailer", k)
+		}
+	}
+}
+
+func (m *MetadataMapper) toHeader(md Metadata, h http.Header, prefix string) []string {
+	match := m.OutgoingMatcher
+	if match == nil {
+		match = OutgoingHeaderMatcher
+	}
+	var keys []string
+	for k, vals := range md {
+		if IsReservedGrpcHeader(http.CanonicalHeaderKey(k)) {
+			continue
+		}
+		name, ok := match(k)
+		if !ok {
+			continue
+		}
+		name = http.CanonicalHeaderKey(prefix + name)
+		for _, v := range vals {
+			if strings.HasSuffix(strings.ToLower(k), "-bin") {
+				v = EncodeBinHeader([]byte(v))
+			}
+			h.Add(name, v)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// EncodeBinHeader encodes a binary metadata value for an http header, as grpc does
+func EncodeBinHeader(v []byte) string {
+	return base64.RawStdEncoding.EncodeToString(v)
+}
+
+// DecodeBinHeader decodes a binary metadata value from an http header, accepting padded and unpadded base64
+func DecodeBinHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
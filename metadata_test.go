@@ -0,0 +1,27 @@
+package util
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataMapperCustomPrefixRoundTrip(t *testing.T) {
+	m := &MetadataMapper{HeaderPrefix: "X-Md-"}
+	md := Metadata{"foo": {"bar"}, "data-bin": {"\x00\x01"}}
+	h := http.Header{}
+	m.ToHeader(md, h)
+	if h.Get("X-Md-Foo") != "bar" {
+		t.Fatalf("expected X-Md-Foo to be set, got %v", h)
+	}
+	h.Set("User-Agent", "test")
+
+	got, err := m.FromHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{"foo": {"bar"}, "data-bin": {"\x00\x01"}, "grpcgateway-user-agent": {"test"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,243 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Stub is a canned response served by a MockServer to requests matching Method, Path and Header.
+// An empty Method matches any method. Path is a path.Match glob, ie. /users/*, unless PathRegexp is set.
+// Body is rendered with Render against MockRequestData, so it may reference the request, ie. {{ .Query.id }}.
+// If JSON is set it is encoded as the body instead. Stubs with a non-zero Times stop matching after that many requests.
+type Stub struct {
+	Method         string
+	Path           string
+	PathRegexp     *regexp.Regexp
+	Header         map[string]string
+	Status         int
+	ResponseHeader map[string]string
+	Body           string
+	JSON           interface{}
+	Times          int
+
+	calls int
+}
+
+// MockRequest is a request received by a MockServer. Stub is nil if no stub matched it.
+type MockRequest struct {
+	Method string
+	URL    string
+	Path   string
+	Header http.Header
+	Body   []byte
+	Stub   *Stub
+}
+
+// MockRequestData is the data Stub bodies are rendered against
+type MockRequestData struct {
+	Method string
+	Path   string
+	Query  map[string]string
+	Header map[string]string
+	Body   string
+	JSON   interface{}
+}
+
+// TestingT is the subset of *testing.T used by MockServer assertions
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// MockServer is an httptest.Server answering requests from declarative stubs and recording every request it receives.
+// Stubs are matched in the order they were added. Unmatched requests get a 404 response.
+type MockServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	stubs    []*Stub
+	requests []*MockRequest
+}
+
+// NewMockServer starts a MockServer with the given stubs. Call Close when done.
+func NewMockServer(stubs ...*Stub) *MockServer {
+	m := &MockServer{stubs: stubs}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+// Stub adds a stub
+func (m *MockServer) Stub(s *Stub) *MockServer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stubs = append(m.stubs, s)
+	return m
+}
+
+// Reset removes every stub and recorded request
+func (m *MockServer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stubs = nil
+	m.requests = nil
+}
+
+// Requests returns every request received so far
+func (m *MockServer) Requests() []*MockRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*MockRequest{}, m.requests...)
+}
+
+// RequestsTo returns the requests received for method and path. An empty method matches any method.
+func (m *MockServer) RequestsTo(method, urlPath string) []*MockRequest {
+	var out []*MockRequest
+	for _, r := range m.Requests() {
+		if (method == "" || r.Method == method) && r.Path == urlPath {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// AssertRequested fails t unless at least one request was received for method and path
+func (m *MockServer) AssertRequested(t TestingT, method, urlPath string) bool {
+	t.Helper()
+	if len(m.RequestsTo(method, urlPath)) == 0 {
+		t.Errorf("mock server: expected a %s %s request, got none", method, urlPath)
+		return false
+	}
+	return true
+}
+
+// AssertRequestCount fails t unless exactly n requests were received for method and path
+func (m *MockServer) AssertRequestCount(t TestingT, method, urlPath string, n int) bool {
+	t.Helper()
+	if got := len(m.RequestsTo(method, urlPath)); got != n {
+		t.Errorf("mock server: expected %d %s %s requests, got %d", n, method, urlPath, got)
+		return false
+	}
+	return true
+}
+
+// AssertAllMatched fails t if any request did not match a stub
+func (m *MockServer) AssertAllMatched(t TestingT) bool {
+	t.Helper()
+	ok := true
+	for _, r := range m.Requests() {
+		if r.Stub == nil {
+			t.Errorf("mock server: unexpected request %s %s", r.Method, r.URL)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (m *MockServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rec := &MockRequest{
+		Method: r.Method,
+		URL:    r.URL.String(),
+		Path:   r.URL.Path,
+		Header: CloneHeader(r.Header),
+		Body:   body,
+	}
+	m.mu.Lock()
+	for _, s := range m.stubs {
+		if s.matches(r) {
+			s.calls++
+			rec.Stub = s
+			break
+		}
+	}
+	m.requests = append(m.requests, rec)
+	m.mu.Unlock()
+
+	if rec.Stub == nil {
+		http.Error(w, "mock server: no stub for "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	rec.Stub.write(w, r, body)
+}
+
+func (s *Stub) matches(r *http.Request) bool {
+	if s.Times > 0 && s.calls >= s.Times {
+		return false
+	}
+	if s.Method != "" && !strings.EqualFold(s.Method, r.Method) {
+		return false
+	}
+	if s.PathRegexp != nil {
+		if !s.PathRegexp.MatchString(r.URL.Path) {
+			return false
+		}
+	} else if s.Path != "" {
+		if ok, _ := path.Match(s.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	for k, v := range s.Header {
+		if r.Header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Stub) write(w http.ResponseWriter, r *http.Request, body []byte) {
+	SetResponseHeaders(s.ResponseHeader, w)
+	status := s.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var out []byte
+	if s.JSON != nil {
+		bits, err := json.Marshal(s.JSON)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = bits
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	} else {
+		rendered, err := RenderErr(s.Body, newMockRequestData(r, body))
+		if err != nil {
+			http.Error(w, "mock server: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = []byte(rendered)
+	}
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+func newMockRequestData(r *http.Request, body []byte) *MockRequestData {
+	data := &MockRequestData{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  map[string]string{},
+		Header: map[string]string{},
+		Body:   string(body),
+	}
+	for k := range r.URL.Query() {
+		data.Query[k] = r.URL.Query().Get(k)
+	}
+	for k := range r.Header {
+		data.Header[k] = r.Header.Get(k)
+	}
+	if len(body) > 0 {
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			data.JSON = v
+		}
+	}
+	return data
+}
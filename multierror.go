@@ -0,0 +1,140 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MultiError collects the errors of an operation that keeps going after a failure, ie. a loop over files.
+// errors.Is and errors.As match against every collected error.
+type MultiError struct {
+	Errors []error
+}
+
+// Append adds the non nil errs, flattening other MultiErrors into this one
+func (m *MultiError) Append(errs ...error) {
+	for _, err := range errs {
+		switch e := err.(type) {
+		case nil:
+		case *MultiError:
+			if e != nil {
+				m.Errors = append(m.Errors, e.Errors...)
+			}
+		default:
+			m.Errors = append(m.Errors, err)
+		}
+	}
+}
+
+// Len returns the number of errors collected
+func (m *MultiError) Len() int {
+	return len(m.Errors)
+}
+
+// ErrorOrNil returns m, or nil if no errors were collected, so it can be returned as an error
+func (m *MultiError) ErrorOrNil() error {
+	if m == nil || len(m.Errors) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (m *MultiError) Error() string {
+	if len(m.Errors) == 1 {
+		return m.Errors[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors occurred:", len(m.Errors))
+	for _, err := range m.Errors {
+		b.WriteString("\n\t* ")
+		b.WriteString(strings.ReplaceAll(err.Error(), "\n", "\n\t  "))
+	}
+	return b.String()
+}
+
+// Unwrap returns the collected errors for errors.Is and errors.As
+func (m *MultiError) Unwrap() []error {
+	return m.Errors
+}
+
+// JoinErrors returns a MultiError of the non nil errs, or nil if there are none
+func JoinErrors(errs ...error) error {
+	m := &MultiError{}
+	m.Append(errs...)
+	return m.ErrorOrNil()
+}
+
+// ErrGroup runs functions concurrently, at most limit at a time, and cancels its context when the first one fails.
+// The zero value runs every function at once and has no context to cancel.
+type ErrGroup struct {
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	first error
+	errs  MultiError
+}
+
+// NewErrGroup returns an ErrGroup and a context derived from ctx that is canceled when a function fails or Wait
+// returns. A limit of 0 or less runs every function at once.
+func NewErrGroup(ctx context.Context, limit int) (*ErrGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &ErrGroup{cancel: cancel}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g, ctx
+}
+
+// Go runs fn in a new goroutine, blocking while limit functions are already running
+func (g *ErrGroup) Go(fn func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+		}()
+		if err := fn(); err != nil {
+			g.mu.Lock()
+			if g.first == nil {
+				g.first = err
+				g.stop()
+				g.errs.Append(err)
+			} else if !errors.Is(err, context.Canceled) {
+				// cancellations caused by the first failure are noise
+				g.errs.Append(err)
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// Wait blocks until every function has returned and returns the first error
+func (g *ErrGroup) Wait() error {
+	g.wg.Wait()
+	g.stop()
+	return g.first
+}
+
+// WaitAll blocks until every function has returned and returns every error as a MultiError, leaving out the
+// context.Canceled errors of functions stopped by the first failure
+func (g *ErrGroup) WaitAll() error {
+	g.wg.Wait()
+	g.stop()
+	return g.errs.ErrorOrNil()
+}
+
+func (g *ErrGroup) stop() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
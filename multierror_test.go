@@ -0,0 +1,19 @@
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrGroupZeroValue(t *testing.T) {
+	var g ErrGroup
+	fail := errors.New("fail")
+	g.Go(func() error { return nil })
+	g.Go(func() error { return fail })
+	if err := g.Wait(); err != fail {
+		t.Fatalf("expected the failure, got %v", err)
+	}
+	if err := g.WaitAll(); !errors.Is(err, fail) {
+		t.Fatalf("expected WaitAll to include the failure, got %v", err)
+	}
+}
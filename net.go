@@ -3,17 +3,15 @@ package util
 import (
 	"context"
 	"github.com/gorilla/sessions"
-	"github.com/rs/cors"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/net/http/httpguts"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -88,10 +86,28 @@ func SetHeaders(headers map[string]string, req *http.Request) {
 	}
 }
 
+// SetForm replaces the body of req with vals encoded as application/x-www-form-urlencoded
 func SetForm(vals map[string]string, req *http.Request) {
+	form := url.Values{}
 	for k, v := range vals {
-		req.Form.Set(k, v)
+		form.Set(k, v)
 	}
+	encoded := form.Encode()
+	req.Body = ioutil.NopCloser(strings.NewReader(encoded))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(encoded)), nil
+	}
+	req.ContentLength = int64(len(encoded))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+}
+
+// SetQuery adds vals to the query string of req
+func SetQuery(vals map[string]string, req *http.Request) {
+	q := req.URL.Query()
+	for k, v := range vals {
+		q.Set(k, v)
+	}
+	req.URL.RawQuery = q.Encode()
 }
 
 func SetBasicAuth(user, password string, req *http.Request) *http.Request {
@@ -99,6 +115,8 @@ func SetBasicAuth(user, password string, req *http.Request) *http.Request {
 	return req
 }
 
+// NewRequest builds a request with optional basic auth and headers. form is added to the query string for GET, HEAD,
+// DELETE, OPTIONS and TRACE requests, which carry no body, or when body is set. Otherwise it is sent as a urlencoded body.
 func NewRequest(method, url, user, password string, headers map[string]string, form map[string]string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -111,11 +129,16 @@ func NewRequest(method, url, user, password string, headers map[string]string, f
 		SetHeaders(headers, req)
 	}
 	if form != nil {
-		SetForm(form, req)
+		if body == nil && methodHasBody(method) {
+			SetForm(form, req)
+		} else {
+			SetQuery(form, req)
+		}
 	}
 	return req, nil
 }
 
+// NewRequestCtx is NewRequest with a context
 func NewRequestCtx(ctx context.Context, method, url, user, password string, headers map[string]string, form map[string]string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -128,15 +151,30 @@ func NewRequestCtx(ctx context.Context, method, url, user, password string, head
 		SetHeaders(headers, req)
 	}
 	if form != nil {
-		SetForm(form, req)
+		if body == nil && methodHasBody(method) {
+			SetForm(form, req)
+		} else {
+			SetQuery(form, req)
+		}
 	}
-	req.WithContext(ctx)
-	return req, nil
+	return req.WithContext(ctx), nil
 }
+
+func methodHasBody(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// HTTPErrorHandler returns an ErrorFunc logging logmsg and writing err as problem+json (see WriteProblem). The status
+// is taken from the code of an *Error in the chain of err, or is code otherwise.
 func HTTPErrorHandler(logmsg string, code int) func(rw http.ResponseWriter, req *http.Request, err error) {
 	return func(rw http.ResponseWriter, req *http.Request, err error) {
-		logrus.Printf("%v\n%v", logmsg, err)
-		rw.WriteHeader(code)
+		p := ProblemFromError(req, err, code)
+		logProblem(req, logmsg, err, p.Status)
+		encodeProblem(rw, p)
 	}
 }
 
@@ -154,10 +192,21 @@ func OnErrorInternal(w http.ResponseWriter, r *http.Request, err string) {
 	http.Error(w, err, http.StatusInternalServerError)
 }
 
+// ProxyRequestFunc returns a reverse proxy director rewriting requests to uRL. form values are added to the query string
+// since the body of a proxied request is streamed through as is. Use it with NewReverseProxy to proxy websocket upgrades too.
 func ProxyRequestFunc(uRL, method, user, password string, headers map[string]string, form map[string]string) func(req *http.Request) {
+	director, err := ProxyRequestFuncErr(uRL, method, user, password, headers, form)
+	if err != nil {
+		logFatal("invalid proxy url", Fields{"url": uRL, "error": err})
+	}
+	return director
+}
+
+// ProxyRequestFuncErr is ProxyRequestFunc returning an error instead of exiting if uRL is invalid
+func ProxyRequestFuncErr(uRL, method, user, password string, headers map[string]string, form map[string]string) (func(req *http.Request), error) {
 	target, err := url.Parse(uRL)
 	if err != nil {
-		log.Fatalln(err.Error())
+		return nil, err
 	}
 	targetQuery := target.RawQuery
 	return func(req *http.Request) {
@@ -168,11 +217,6 @@ func ProxyRequestFunc(uRL, method, user, password string, headers map[string]str
 		if user != "" && password != "" {
 			req.SetBasicAuth(user, password)
 		}
-		if form != nil {
-			for k, v := range form {
-				req.Form.Set(k, v)
-			}
-		}
 		if headers != nil {
 			for k, v := range headers {
 				req.Header.Set(k, v)
@@ -183,26 +227,34 @@ func ProxyRequestFunc(uRL, method, user, password string, headers map[string]str
 		} else {
 			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
 		}
+		if form != nil {
+			SetQuery(form, req)
+		}
 		if _, ok := req.Header["User-Agent"]; !ok {
 			// explicitly disable User-Agent so it's not set to default value
 			req.Header.Set("User-Agent", "")
 		}
-	}
+	}, nil
 }
 
 func Ping(endpoint string) error {
-	_, err := net.DialTimeout("tcp", endpoint, 250*time.Millisecond)
+	return PingTimeout(endpoint, 250*time.Millisecond)
+}
+
+// PingTimeout checks that a tcp connection can be opened to endpoint within timeout
+func PingTimeout(endpoint string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", endpoint, timeout)
 	if err != nil {
 		return err
 	}
-	return nil
+	return conn.Close()
 }
 
 func PingLog(endpoint string, sleep time.Duration) {
 	for {
 		_, err := net.DialTimeout("tcp", endpoint, 250*time.Millisecond)
 		if err != nil {
-			log.Println("endpoint unreachable: ", err.Error())
+			GetLogger().Warn("endpoint unreachable", Fields{"endpoint": endpoint, "error": err})
 		}
 		time.Sleep(sleep)
 	}
@@ -210,13 +262,15 @@ func PingLog(endpoint string, sleep time.Duration) {
 
 // SignalRunner runs a runner function until an interrupt signal is received, at which point it
 // will call stopper.
+//
+// Deprecated: use Lifecycle, which drains servers with a deadline and runs shutdown hooks.
 func SignalRunner(runner, stopper func()) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, os.Kill)
 	go func() {
 		runner()
 	}()
-	log.Println("hit Ctrl-C to shutdown")
+	GetLogger().Info("hit Ctrl-C to shutdown")
 	select {
 	case <-signals:
 		stopper()
@@ -310,9 +364,36 @@ func HeaderContainsToken(header http.Header, headerKey, tokenKey string) bool {
 	return httpguts.HeaderValuesContainsToken(header[headerKey], tokenKey)
 }
 
+// AcceptsEncoding reports whether the Accept-Encoding header allows encoding, honouring q-values and the * wildcard
+func AcceptsEncoding(header http.Header, encoding string) bool {
+	wildcard := false
+	for _, v := range header["Accept-Encoding"] {
+		for _, part := range strings.Split(v, ",") {
+			name, q := part, 1.0
+			if semi := strings.IndexByte(part, ';'); semi >= 0 {
+				name = part[:semi]
+				param := strings.TrimSpace(part[semi+1:])
+				if strings.HasPrefix(param, "q=") {
+					if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+			name = strings.TrimSpace(name)
+			switch {
+			case strings.EqualFold(name, encoding):
+				return q > 0
+			case name == "*":
+				wildcard = q > 0
+			}
+		}
+	}
+	return wildcard
+}
+
 func GetTokenFromHeader(header http.Header, headerKey, tokenKey string) string {
 	if !HeaderContainsToken(header, headerKey, tokenKey) {
-		logrus.Printf("header key- %s does not contain token key- %s", headerKey, tokenKey)
+		GetLogger().Debug("header does not contain token", Fields{"header": headerKey, "token": tokenKey})
 		return ""
 	}
 	return header.Get(tokenKey)
@@ -322,35 +403,6 @@ func ValidHeaderField(s string) bool {
 	return httpguts.ValidHeaderFieldName(s)
 }
 
-func NewCors(origins, methods, headers []string, creds, options, debug bool, maxAge int) *cors.Cors {
-	if methods == nil {
-		methods = []string{
-			http.MethodHead,
-			http.MethodGet,
-			http.MethodPost,
-			http.MethodPut,
-			http.MethodPatch,
-			http.MethodDelete,
-		}
-	}
-	if origins == nil {
-		origins = []string{"*"}
-	}
-	if headers == nil {
-		headers = []string{"*"}
-	}
-	opts := cors.Options{
-		AllowedOrigins:     origins,
-		AllowedMethods:     methods,
-		AllowedHeaders:     headers,
-		MaxAge:             maxAge,
-		AllowCredentials:   creds,
-		OptionsPassthrough: options,
-		Debug:              debug,
-	}
-	return cors.New(opts)
-}
-
 func ExecHandler(ctx context.Context, name, dir string, args ...string) http.HandlerFunc {
 	type Command struct {
 		Name   string   `json:"name"`
@@ -364,7 +416,7 @@ func ExecHandler(ctx context.Context, name, dir string, args ...string) http.Han
 		Args: args,
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		bits, err := Exec(ctx, name, dir, args)
+		bits, err := Exec(ctx, name, dir, nil, args...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -377,9 +429,3 @@ func ExecHandler(ctx context.Context, name, dir string, args ...string) http.Han
 func NewSessionCookieStore(key string) *sessions.CookieStore {
 	return sessions.NewCookieStore([]byte(key))
 }
-
-type CorsConfig struct {
-	Origins, Methods, Headers []string
-	Creds, Options, Debug     bool
-	MaxAge                    int
-}
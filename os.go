@@ -1,7 +1,6 @@
 package util
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/binary"
@@ -16,8 +15,6 @@ import (
 	"sync"
 )
 
-var errFmt = "ERROR: %s\n%s\n"
-
 func init() {
 	fs = &afero.Afero{
 		Fs: afero.NewOsFs(),
@@ -225,41 +222,46 @@ func CopyFile(srcfile, dstfile string) (*afero.File, error) {
 }
 
 func ScanAndReplaceFile(f afero.File, replacements ...string) {
+	if err := ScanAndReplaceFileErr(f, replacements...); err != nil {
+		panic(err.Error())
+	}
+	GetLogger().Info("scanned and replaced", Fields{"file": f.Name()})
+}
+
+// ScanAndReplaceFileErr is ScanAndReplaceFile returning an error instead of panicking. The file is rewritten with
+// every occurrence of the replacements, given as old, new pairs, replaced.
+func ScanAndReplaceFileErr(f afero.File, replacements ...string) error {
 	nm := f.Name()
 	d, err := ioutil.ReadAll(f)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("failed to read %s: %s", nm, err)
 	}
-	if err := fs.Remove(f.Name()); err != nil {
-		panic(err.Error())
-	}
-	scanner := bufio.NewScanner(strings.NewReader(fmt.Sprintf("%s", d)))
-	rep := strings.NewReplacer(replacements...)
-	var newstr string
-	for scanner.Scan() {
-		newstr = rep.Replace(scanner.Text())
-		if err := scanner.Err(); err != nil {
-			fmt.Println(err.Error())
-			break
-		}
+	newstr := strings.NewReplacer(replacements...).Replace(string(d))
+	if err := fs.Remove(nm); err != nil {
+		return fmt.Errorf("failed to remove %s: %s", nm, err)
 	}
 	newf, err := fs.Create(nm)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("failed to create %s: %s", nm, err)
 	}
-	_, err = io.WriteString(newf, newstr)
-	if err != nil {
-		Exit(1, errFmt, err, "failed to write string to new file")
+	if _, err = io.WriteString(newf, newstr); err != nil {
+		newf.Close()
+		return fmt.Errorf("failed to write string to new file: %s", err)
 	}
-	fmt.Println("successfully scanned and replaced: " + f.Name())
+	return newf.Close()
 }
 
 func ChDir(path string) {
-	if err := os.Chdir(path); err != nil {
-		Exit(1, errFmt, err, "failed to change working directory")
+	if err := ChDirErr(path); err != nil {
+		exitErr(1, "failed to change working directory", err)
 	}
 }
 
+// ChDirErr is ChDir returning an error instead of exiting
+func ChDirErr(path string) error {
+	return os.Chdir(path)
+}
+
 func Exec(ctx context.Context, name, dir string, env []string, args ...string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, name, args...)
 	if env != nil {
@@ -271,6 +273,28 @@ func Exec(ctx context.Context, name, dir string, env []string, args ...string) (
 	return cmd.CombinedOutput()
 }
 
+// ExecStream runs a command like Exec, writing its output to stdout and stderr as it is produced.
+// It returns the exit code of the command, which is -1 if the command did not run to completion.
+func ExecStream(ctx context.Context, name, dir string, env []string, stdout, stderr io.Writer, args ...string) (int, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if env != nil {
+		cmd.Env = env
+	}
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return exit.ExitCode(), err
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
 // consistentReadSync is the main functionality of ConsistentRead but
 // introduces a sync callback that can be used by the tests to mutate the file
 // from which the test data is being read
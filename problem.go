@@ -0,0 +1,90 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Code, Details and RequestID are extension members.
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      ErrorCode              `json:"code,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// ProblemFromError converts err to a Problem for request r. The status comes from the code of an *Error in the
+// chain of err, or fallback if there is none. The messages of errors without a code are not exposed in 5xx responses.
+func ProblemFromError(r *http.Request, err error, fallback int) *Problem {
+	status := fallback
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	p := &Problem{Type: "about:blank"}
+	var e *Error
+	if errors.As(err, &e) {
+		status = e.HTTPStatus()
+		p.Code = e.Code
+		p.Detail = e.Message
+		p.Details = e.Details
+	} else if code := ErrorCodeOf(err); code != CodeUnknown {
+		status = code.HTTPStatus()
+		p.Code = code
+	} else if status < 500 {
+		p.Detail = err.Error()
+	}
+	p.Status = status
+	p.Title = http.StatusText(status)
+	if p.Title == "" {
+		p.Title = string(p.Code)
+	}
+	if r != nil {
+		p.Instance = r.URL.Path
+		p.RequestID = RequestIDFromContext(r.Context())
+	}
+	return p
+}
+
+// WriteProblem is an ErrorFunc writing err as an application/problem+json response. Server errors are logged with
+// their stack through the logger of the request context.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := ProblemFromError(r, err, http.StatusInternalServerError)
+	if p.Status >= 500 {
+		logProblem(r, "request failed", err, p.Status)
+	}
+	encodeProblem(w, p)
+}
+
+func logProblem(r *http.Request, msg string, err error, status int) {
+	fields := Fields{"error": err, "status": status, "method": r.Method, "path": r.URL.Path}
+	var e *Error
+	if errors.As(err, &e) {
+		fields["code"] = e.Code
+		if stack := e.StackTrace(); stack != nil {
+			fields["stack"] = stack
+		}
+	}
+	LoggerFromContext(r.Context()).Error(msg, fields)
+}
+
+func encodeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,338 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitResult is the outcome of a single RateLimiter.Allow call
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limiter is back to its full quota
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed. It is zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// RateLimiter decides whether a request identified by key may proceed
+type RateLimiter interface {
+	Allow(key string) RateLimitResult
+}
+
+// RateLimitKeyFunc extracts the key a request is rate limited by
+type RateLimitKeyFunc func(*http.Request) string
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type tokenBucketLimiter struct {
+	mu      sync.Mutex
+	burst   int
+	rate    float64 // tokens per second
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewTokenBucketLimiter returns a RateLimiter that refills limit tokens every period, holding at most burst tokens per key
+func NewTokenBucketLimiter(limit int, period time.Duration, burst int) RateLimiter {
+	if burst < 1 {
+		burst = limit
+	}
+	return &tokenBucketLimiter{
+		burst:   burst,
+		rate:    float64(limit) / period.Seconds(),
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+	}
+}
+
+func (l *tokenBucketLimiter) Allow(key string) RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	res := RateLimitResult{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = l.duration(float64(l.burst) - b.tokens)
+	return res
+}
+
+func (l *tokenBucketLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have been full for a while so the map does not grow without bound
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	full := l.duration(float64(l.burst))
+	if now.Sub(l.swept) < full {
+		return
+	}
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+}
+
+type window struct {
+	start     time.Time
+	count     int
+	prevCount int
+}
+
+type slidingWindowLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	size    time.Duration
+	windows map[string]*window
+	swept   time.Time
+}
+
+// NewSlidingWindowLimiter returns a RateLimiter that allows limit requests per key in any window of the given size.
+// It approximates the window by weighting the previous fixed window's count, so memory stays constant per key.
+func NewSlidingWindowLimiter(limit int, size time.Duration) RateLimiter {
+	return &slidingWindowLimiter{
+		limit:   limit,
+		size:    size,
+		windows: map[string]*window{},
+		swept:   time.Now(),
+	}
+}
+
+func (l *slidingWindowLimiter) Allow(key string) RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	start := now.Truncate(l.size)
+	w, ok := l.windows[key]
+	if !ok {
+		w = &window{start: start}
+		l.windows[key] = w
+	}
+	switch elapsed := start.Sub(w.start); {
+	case elapsed == l.size:
+		w.prevCount, w.count = w.count, 0
+		w.start = start
+	case elapsed > l.size:
+		w.prevCount, w.count = 0, 0
+		w.start = start
+	}
+
+	into := now.Sub(start)
+	weight := 1 - float64(into)/float64(l.size)
+	estimate := float64(w.prevCount)*weight + float64(w.count)
+
+	res := RateLimitResult{Limit: l.limit, Reset: l.size - into}
+	if estimate+1 <= float64(l.limit) {
+		w.count++
+		estimate++
+		res.Allowed = true
+	} else if w.prevCount > 0 && float64(w.count) < float64(l.limit) {
+		// wait for enough of the previous window to slide out
+		need := (estimate + 1 - float64(l.limit)) / float64(w.prevCount)
+		res.RetryAfter = time.Duration(need * float64(l.size))
+	} else {
+		res.RetryAfter = l.size - into
+	}
+	if remaining := float64(l.limit) - estimate; remaining > 0 {
+		res.Remaining = int(remaining)
+	}
+	if w.prevCount > 0 {
+		res.Reset += l.size
+	}
+	return res
+}
+
+func (l *slidingWindowLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < 2*l.size {
+		return
+	}
+	for k, w := range l.windows {
+		if now.Sub(w.start) > 2*l.size {
+			delete(l.windows, k)
+		}
+	}
+	l.swept = now
+}
+
+// RateLimit returns middleware that limits requests by the key returned from keyFn, setting the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers on every response and Retry-After on rejected ones.
+// Requests for which keyFn returns an empty string are not limited.
+func RateLimit(limiter RateLimiter, keyFn RateLimitKeyFunc) HandlerFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res := limiter.Allow(key)
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// KeyByIP keys requests by the client ip address. If trustForwarded is true, the first address in X-Forwarded-For is used when present.
+// Clients can spoof that header, so prefer KeyByClientIP behind a known set of proxies.
+func KeyByIP(trustForwarded bool) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		if trustForwarded {
+			if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+				return strings.TrimSpace(strings.Split(xff, ",")[0])
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// KeyByClientIP keys requests by the client ip address as resolved by proxies.ClientIP, believing forwarding headers
+// only when they were set by a trusted proxy
+func KeyByClientIP(proxies TrustedProxies) RateLimitKeyFunc {
+	return proxies.ClientIP
+}
+
+// KeyByHeader keys requests by the value of a request header, ie. an api key
+func KeyByHeader(header string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// KeyByJWTSubject keys requests by the sub claim of a bearer token signed with signKey (see GenerateJWT).
+// Requests without a valid token fall back to fallback, which may be nil to leave them unlimited.
+func KeyByJWTSubject(signKey string, fallback RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, func(t *jwt.Token) (interface{}, error) {
+				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+				}
+				return []byte(signKey), nil
+			})
+			if sub, ok := claims["sub"].(string); err == nil && ok && sub != "" {
+				return "sub:" + sub
+			}
+		}
+		if fallback == nil {
+			return ""
+		}
+		return fallback(r)
+	}
+}
+
+// RetryAfterTripperware retries requests rejected with 429 Too Many Requests or 503 Service Unavailable after waiting for
+// the duration in their Retry-After header. Waits longer than maxWait are not attempted and the response is returned as is.
+// Requests with a body are only retried if their GetBody func is set, as it is by http.NewRequest.
+func RetryAfterTripperware(maxRetries int, maxWait time.Duration) Tripperware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for attempt := 0; ; attempt++ {
+				resp, err := next.RoundTrip(req)
+				if err != nil || attempt >= maxRetries {
+					return resp, err
+				}
+				if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+					return resp, nil
+				}
+				wait, ok := ParseRetryAfter(resp.Header.Get("Retry-After"))
+				if !ok || wait > maxWait {
+					return resp, nil
+				}
+				if req.Body != nil && req.Body != http.NoBody {
+					if req.GetBody == nil {
+						return resp, nil
+					}
+					body, err := req.GetBody()
+					if err != nil {
+						return resp, nil
+					}
+					req = req.Clone(req.Context())
+					req.Body = body
+				}
+				resp.Body.Close()
+				if err := sleepCtx(req.Context(), wait); err != nil {
+					return nil, err
+				}
+			}
+		})
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value given either in seconds or as an http date
+func ParseRetryAfter(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			secs = 0
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(t); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
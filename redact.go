@@ -0,0 +1,268 @@
+package util
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secret values in config dumps, errors and recorded requests
+const Redacted = "[REDACTED]"
+
+// DefaultRedactKeyPatterns match config keys whose values are secret. They are anchored to the segments of a key,
+// separated by _ . or -, so "db_password" and "client.secret" match but "compass" and "tokens_per_sec" do not.
+var DefaultRedactKeyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(^|[_.-])([a-z]*pass(word|wd|phrase)|pass)($|[_.-])`),
+	regexp.MustCompile(`(?i)(^|[_.-])[a-z]*secrets?($|[_.-])`),
+	regexp.MustCompile(`(?i)(^|[_.-])[a-z]*token($|[_.-])`),
+	regexp.MustCompile(`(?i)(^|[_.-])(api|private|access|signing|sign|encryption|session)[_-]?keys?($|[_.-])`),
+	regexp.MustCompile(`(?i)(^|[_.-])keys?$`),
+	regexp.MustCompile(`(?i)(^|[_.-])credentials?($|[_.-])`),
+	regexp.MustCompile(`(?i)(^|[_.-])auth(orization)?$`),
+	regexp.MustCompile(`(?i)(^|[_.-])dsn$`),
+	regexp.MustCompile(`(?i)(^|[_.-])cookies?$`),
+}
+
+// DefaultRedactValuePatterns match secret looking values regardless of their key. When a pattern has a capture group
+// only the first group is redacted, ie. the password of a url.
+var DefaultRedactValuePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`),
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]*:([^/\s@]+)@`),
+	regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`),
+	regexp.MustCompile(`^(?i)bearer\s+\S+$`),
+	regexp.MustCompile(`^gh[pousr]_[A-Za-z0-9]{36,}$`),
+	regexp.MustCompile(`^xox[abprs]-[A-Za-z0-9-]+$`),
+}
+
+// Redactor replaces secrets in nested config values with Redacted. A value is secret if its key matches one of
+// KeyPatterns or, for strings, the value matches one of ValuePatterns. Allow lists dotted key paths, which may be
+// path.Match globs, ie. "database.host" or "*.public_key", that are never redacted. Secret strings become Redacted
+// and other secret values their zero value, so a redacted dump keeps the types of the config.
+type Redactor struct {
+	KeyPatterns   []*regexp.Regexp
+	ValuePatterns []*regexp.Regexp
+	Allow         []string
+}
+
+// NewRedactor returns a Redactor with the default key and value patterns
+func NewRedactor(allow ...string) *Redactor {
+	return &Redactor{
+		KeyPatterns:   DefaultRedactKeyPatterns,
+		ValuePatterns: DefaultRedactValuePatterns,
+		Allow:         allow,
+	}
+}
+
+var (
+	redactorMu      sync.RWMutex
+	defaultRedactor = NewRedactor()
+)
+
+// SetRedactor replaces the Redactor applied to config dumps by the package
+func SetRedactor(r *Redactor) {
+	redactorMu.Lock()
+	defer redactorMu.Unlock()
+	defaultRedactor = r
+}
+
+// GetRedactor returns the Redactor applied to config dumps by the package
+func GetRedactor() *Redactor {
+	redactorMu.RLock()
+	defer redactorMu.RUnlock()
+	return defaultRedactor
+}
+
+// Redact returns a copy of v with secrets replaced. Maps and slices are copied; v itself is not modified.
+func (r *Redactor) Redact(v interface{}) interface{} {
+	return r.redact("", v)
+}
+
+// RedactMap is Redact for a map of settings, ie. viper.AllSettings()
+func (r *Redactor) RedactMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return r.redact("", m).(map[string]interface{})
+}
+
+// RedactValue returns value, the value of key, with any secret replaced
+func (r *Redactor) RedactValue(key, value string) string {
+	if r.allowed(key) {
+		return value
+	}
+	if r.secretKey(key) {
+		return Redacted
+	}
+	return r.redactString(value)
+}
+
+func (r *Redactor) redact(keyPath string, v interface{}) interface{} {
+	if keyPath != "" && r.allowed(keyPath) {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			out[k] = r.redactChild(joinKeyPath(keyPath, k), k, child)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(val))
+		for k, child := range val {
+			ks := fmt.Sprint(k)
+			out[k] = r.redactChild(joinKeyPath(keyPath, ks), ks, child)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(val))
+		for k, child := range val {
+			p := joinKeyPath(keyPath, k)
+			switch {
+			case r.allowed(p):
+				out[k] = child
+			case r.secretKey(k):
+				out[k] = Redacted
+			default:
+				out[k] = r.redactString(child)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			out[i] = r.redact(keyPath, child)
+		}
+		return out
+	case []string:
+		out := make([]string, len(val))
+		for i, child := range val {
+			out[i] = r.redactString(child)
+		}
+		return out
+	case string:
+		return r.redactString(val)
+	}
+	return v
+}
+
+func (r *Redactor) redactChild(keyPath, key string, v interface{}) interface{} {
+	if r.allowed(keyPath) {
+		return v
+	}
+	if r.secretKey(key) {
+		switch v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, map[string]string, []interface{}:
+			// a section named like a secret, ie. credentials, has every value redacted
+			return redactAll(v)
+		}
+		return redactScalar(v)
+	}
+	return r.redact(keyPath, v)
+}
+
+func redactAll(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			out[k] = redactAll(child)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(val))
+		for k, child := range val {
+			out[k] = redactAll(child)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(val))
+		for k := range val {
+			out[k] = Redacted
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			out[i] = redactAll(child)
+		}
+		return out
+	}
+	return redactScalar(v)
+}
+
+// redactScalar replaces a secret value keeping its type, so dumps still decode into the original config: strings
+// become Redacted and other values their zero value. Empty values are left as they are.
+func redactScalar(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if val == "" {
+			return val
+		}
+		return Redacted
+	case []string:
+		out := make([]string, len(val))
+		for i := range out {
+			out[i] = Redacted
+		}
+		return out
+	}
+	return reflect.Zero(reflect.TypeOf(v)).Interface()
+}
+
+func (r *Redactor) redactString(s string) string {
+	for _, p := range r.ValuePatterns {
+		loc := p.FindStringSubmatchIndex(s)
+		if loc == nil {
+			continue
+		}
+		if len(loc) >= 4 && loc[2] >= 0 {
+			return s[:loc[2]] + Redacted + s[loc[3]:]
+		}
+		return Redacted
+	}
+	return s
+}
+
+func (r *Redactor) secretKey(key string) bool {
+	for _, p := range r.KeyPatterns {
+		if p.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) allowed(keyPath string) bool {
+	keyPath = strings.ToLower(keyPath)
+	for _, a := range r.Allow {
+		a = strings.ToLower(a)
+		if a == keyPath {
+			return true
+		}
+		if ok, _ := path.Match(a, keyPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// joinKeyPath joins config keys with dots, which path.Match treats like any other character
+func joinKeyPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// RedactedSettings returns viper.AllSettings() with secrets replaced by the package Redactor
+func RedactedSettings() map[string]interface{} {
+	return GetRedactor().RedactMap(viper.AllSettings())
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactorKeys(t *testing.T) {
+	r := NewRedactor()
+	for key, secret := range map[string]bool{
+		"password":          true,
+		"db_password":       true,
+		"database.passwd":   true,
+		"dbpassword":        true,
+		"pass":              true,
+		"ssh-passphrase":    true,
+		"secret":            true,
+		"client_secret":     true,
+		"secrets":           true,
+		"token":             true,
+		"access_token":      true,
+		"github.token":      true,
+		"api_key":           true,
+		"apikey":            true,
+		"signing-key":       true,
+		"key":               true,
+		"tls.key":           true,
+		"credentials":       true,
+		"auth":              true,
+		"basic_auth":        true,
+		"dsn":               true,
+		"cookie":            true,
+		"compass":           false,
+		"bypass":            false,
+		"passenger":         false,
+		"tokens_per_sec":    false,
+		"secretary_name":    false,
+		"keyboard":          false,
+		"monkey":            false,
+		"author":            false,
+		"public_key_path":   false,
+		"cookie_name":       false,
+		"host":              false,
+		"credential_source": true,
+	} {
+		if got := r.secretKey(key); got != secret {
+			t.Errorf("key %s: expected secret %v, got %v", key, secret, got)
+		}
+	}
+}
+
+func TestRedactorKeepsTypes(t *testing.T) {
+	got := NewRedactor().RedactMap(map[string]interface{}{
+		"port":           8080,
+		"tokens_per_sec": 100,
+		"pin_password":   1234,
+		"debug_secret":   true,
+		"token":          "abc",
+		"empty_token":    "",
+		"credentials":    map[string]interface{}{"user": "admin", "retries": 3},
+	})
+	want := map[string]interface{}{
+		"port":           8080,
+		"tokens_per_sec": 100,
+		"pin_password":   0,
+		"debug_secret":   false,
+		"token":          Redacted,
+		"empty_token":    "",
+		"credentials":    map[string]interface{}{"user": Redacted, "retries": 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"github.com/spf13/viper"
 	"html/template"
@@ -14,64 +15,78 @@ import (
 )
 
 func RenderTmpl(t string, data interface{}) string {
-	if strings.Contains(t, "{{") {
-		tmpl, err := template.New("").Funcs(sprig.GenericFuncMap()).Parse(t)
-		FatalIfErr(err, "failed to create template to render string", t)
-		buf := bytes.NewBuffer(nil)
-		if err := tmpl.Execute(buf, data); err != nil {
-			FatalIfErr(err, "failed to render string at execution", t)
-		}
-		return buf.String()
+	out, err := RenderTmplErr(t, data)
+	FatalIfErr(err, "failed to render string", t)
+	return out
+}
+
+// RenderTmplErr is RenderTmpl returning an error instead of exiting if t is not a valid template or fails to execute
+func RenderTmplErr(t string, data interface{}) (string, error) {
+	if !strings.Contains(t, "{{") {
+		return t, nil
 	}
-	return t
+	tmpl, err := template.New("").Funcs(sprig.GenericFuncMap()).Parse(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to create template to render string: %s", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to render string at execution: %s", err)
+	}
+	return buf.String(), nil
 }
 
 func WalkTemplatesFromConfig(dir string, outDir string) {
-	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			Exit(1, errFmt, err, "error walking path")
-		}
-		if strings.Contains(path, ".tmpl") {
-			b, err := ioutil.ReadFile(path)
-			newt, err := template.New(info.Name()).Funcs(sprig.GenericFuncMap()).Parse(string(b))
-			if err != nil {
-				return err
-			}
-
-			f, err := fs.Create(outDir + "/" + strings.TrimSuffix(info.Name(), ".tmpl"))
-			if err != nil {
-				return err
-			}
-			return newt.Execute(f, viper.AllSettings())
-		}
-		return nil
-	}); err != nil {
-		Exit(1, errFmt, err, "failed to walk templates")
-	}
+	WalkTemplates(dir, outDir, viper.AllSettings())
+}
+
+// WalkTemplatesFromConfigErr is WalkTemplatesFromConfig returning an error instead of exiting
+func WalkTemplatesFromConfigErr(dir string, outDir string) error {
+	return WalkTemplatesErr(dir, outDir, viper.AllSettings())
 }
 
 func WalkTemplates(dir, outDir string, data interface{}) {
-	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	if err := WalkTemplatesErr(dir, outDir, data); err != nil {
+		exitErr(1, "failed to walk templates", err)
+	}
+}
+
+// WalkTemplatesErr is WalkTemplates returning an error instead of exiting. Every template is rendered even if some
+// fail, and the failures are returned together as a MultiError.
+func WalkTemplatesErr(dir, outDir string, data interface{}) error {
+	errs := &MultiError{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			Exit(1, errFmt, err, "error walking path")
+			errs.Append(fmt.Errorf("error walking path %s: %s", path, err))
+			return nil
 		}
 		if strings.Contains(path, ".tmpl") {
-			b, err := ioutil.ReadFile(path)
-			newt, err := template.New(info.Name()).Funcs(sprig.GenericFuncMap()).Parse(string(b))
-			if err != nil {
-				return err
-			}
-
-			f, err := fs.Create(outDir + "/" + strings.TrimSuffix(info.Name(), ".tmpl"))
-			if err != nil {
-				return err
-			}
-			return newt.Execute(f, data)
+			errs.Append(renderTemplateFile(path, outDir+"/"+strings.TrimSuffix(info.Name(), ".tmpl"), data))
 		}
 		return nil
-	}); err != nil {
-		Exit(1, errFmt, err, "failed to walk templates")
+	})
+	errs.Append(err)
+	return errs.ErrorOrNil()
+}
+
+func renderTemplateFile(path, out string, data interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
 	}
+	newt, err := template.New(filepath.Base(path)).Funcs(sprig.GenericFuncMap()).Parse(string(b))
+	if err != nil {
+		return fmt.Errorf("failed to parse template %s: %s", path, err)
+	}
+	f, err := fs.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := newt.Execute(f, data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to execute template %s: %s", path, err)
+	}
+	return f.Close()
 }
 
 type AssetFunc func(string) ([]byte, error)
@@ -83,9 +98,9 @@ func loadDirectory(dfn AssetDirFunc, afn AssetFunc, directory string) (*template
 	return assetToTmpl(dfn, afn, tmpl, directory)
 }
 
-func loadHtmlDirectory(dfn AssetDirFunc, afn AssetFunc, directory string) (*template.Template, error) {
+func loadHtmlDirectory(dfn AssetDirFunc, afn AssetFunc, directory string, funcs template.FuncMap) (*template.Template, error) {
 	var tmpl *template.Template
-	return htmlassetToTmpl(dfn, afn, tmpl, directory)
+	return htmlassetToTmpl(dfn, afn, tmpl, directory, funcs)
 }
 
 func assetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, directory string) (*template.Template, error) {
@@ -119,7 +134,7 @@ func assetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, direc
 	return tmpl, nil
 }
 
-func htmlassetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, directory string) (*template.Template, error) {
+func htmlassetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, directory string, funcs template.FuncMap) (*template.Template, error) {
 	files, err := dfn(directory)
 	if err != nil {
 		return tmpl, err
@@ -141,6 +156,9 @@ func htmlassetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, d
 			tmpl = tmpl.New(name)
 		}
 		tmpl.Funcs(sprig.GenericFuncMap())
+		if funcs != nil {
+			tmpl.Funcs(funcs)
+		}
 
 		if _, err = tmpl.Parse(string(contents)); err != nil {
 			return tmpl, err
@@ -150,8 +168,23 @@ func htmlassetToTmpl(dfn AssetDirFunc, afn AssetFunc, tmpl *template.Template, d
 	return tmpl, nil
 }
 
+// ParseAssets is MustParseAssets returning an error instead of panicking
+func ParseAssets(dfn AssetDirFunc, afn AssetFunc, directory string) (*template.Template, error) {
+	return loadDirectory(dfn, afn, directory)
+}
+
+// ParseHtmlAssets is MustParseHtmlAssets returning an error instead of panicking
+func ParseHtmlAssets(dfn AssetDirFunc, afn AssetFunc, directory string) (*template.Template, error) {
+	return loadHtmlDirectory(dfn, afn, directory, nil)
+}
+
+// ParseHtmlAssetsFuncs is MustParseHtmlAssetsFuncs returning an error instead of panicking
+func ParseHtmlAssetsFuncs(dfn AssetDirFunc, afn AssetFunc, directory string, funcs template.FuncMap) (*template.Template, error) {
+	return loadHtmlDirectory(dfn, afn, directory, funcs)
+}
+
 func MustParseAssets(dfn AssetDirFunc, afn AssetFunc, directory string) *template.Template {
-	if tmpl, err := loadDirectory(dfn, afn, directory); err != nil {
+	if tmpl, err := ParseAssets(dfn, afn, directory); err != nil {
 		panic(err)
 	} else {
 		return tmpl
@@ -159,7 +192,12 @@ func MustParseAssets(dfn AssetDirFunc, afn AssetFunc, directory string) *templat
 }
 
 func MustParseHtmlAssets(dfn AssetDirFunc, afn AssetFunc, directory string) *template.Template {
-	if tmpl, err := loadHtmlDirectory(dfn, afn, directory); err != nil {
+	return MustParseHtmlAssetsFuncs(dfn, afn, directory, nil)
+}
+
+// MustParseHtmlAssetsFuncs is MustParseHtmlAssets with additional template functions, ie. CSRFFuncMap.
+func MustParseHtmlAssetsFuncs(dfn AssetDirFunc, afn AssetFunc, directory string, funcs template.FuncMap) *template.Template {
+	if tmpl, err := ParseHtmlAssetsFuncs(dfn, afn, directory, funcs); err != nil {
 		panic(err)
 	} else {
 		return tmpl
@@ -178,9 +216,44 @@ func MustExecHtmlAssets(dfn AssetDirFunc, afn AssetFunc, dir string, data interf
 	return tmpl.Execute(w, data)
 }
 
+// MustExecHtmlAssetsFuncs is MustExecHtmlAssets with additional template functions, ie. CSRFFuncMap.
+func MustExecHtmlAssetsFuncs(dfn AssetDirFunc, afn AssetFunc, dir string, data interface{}, w io.Writer, funcs template.FuncMap) error {
+	tmpl := MustParseHtmlAssetsFuncs(dfn, afn, dir, funcs)
+	return tmpl.Execute(w, data)
+}
+
+// ExecAssets is MustExecAssets returning parse errors instead of panicking
+func ExecAssets(dfn AssetDirFunc, afn AssetFunc, dir string, data interface{}, w io.Writer) error {
+	tmpl, err := ParseAssets(dfn, afn, dir)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// ExecHtmlAssets is MustExecHtmlAssets returning parse errors instead of panicking
+func ExecHtmlAssets(dfn AssetDirFunc, afn AssetFunc, dir string, data interface{}, w io.Writer) error {
+	return ExecHtmlAssetsFuncs(dfn, afn, dir, data, w, nil)
+}
+
+// ExecHtmlAssetsFuncs is MustExecHtmlAssetsFuncs returning parse errors instead of panicking
+func ExecHtmlAssetsFuncs(dfn AssetDirFunc, afn AssetFunc, dir string, data interface{}, w io.Writer, funcs template.FuncMap) error {
+	tmpl, err := ParseHtmlAssetsFuncs(dfn, afn, dir, funcs)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
 func RenderFilesToResponseWriter(w http.ResponseWriter, relTmplPath string, data interface{}) {
+	RenderFilesToResponseWriterFuncs(w, relTmplPath, data, nil)
+}
+
+// RenderFilesToResponseWriterFuncs is RenderFilesToResponseWriter with additional template functions, ie. CSRFFuncMap.
+func RenderFilesToResponseWriterFuncs(w http.ResponseWriter, relTmplPath string, data interface{}, funcs template.FuncMap) {
 	cwd, _ := os.Getwd()
-	t, err := template.ParseFiles(filepath.Join(cwd, relTmplPath))
+	path := filepath.Join(cwd, relTmplPath)
+	t, err := template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
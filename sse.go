@@ -0,0 +1,429 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a server-sent event. Event is the event type, which EventSource clients treat as "message" when empty.
+type Event struct {
+	ID    string        `json:"id,omitempty"`
+	Event string        `json:"event,omitempty"`
+	Data  string        `json:"data"`
+	Retry time.Duration `json:"retry,omitempty"`
+}
+
+// WriteTo writes e in the text/event-stream format
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// SSEBrokerOptions configures NewSSEBroker. Heartbeat is the interval between keep alive comments and defaults to
+// 15 seconds. BufferSize events are kept per topic for Last-Event-ID replay, 100 by default. ClientBuffer is how many
+// events may be queued for a slow subscriber, 16 by default, before it is disconnected to catch up by replaying.
+// When Topics is set clients may only subscribe to the topics it lists; others are answered with 404.
+// A topic without subscribers is deleted, along with its buffered events, once nothing has been published to it for
+// TopicTTL, 10 minutes by default. Expired topics are deleted as the broker is used; ForgetTopic deletes one right away.
+type SSEBrokerOptions struct {
+	Heartbeat    time.Duration
+	BufferSize   int
+	ClientBuffer int
+	Topics       []string
+	TopicTTL     time.Duration
+}
+
+type sseTopic struct {
+	events []Event
+	seqs   []uint64
+	subs   map[*sseSubscriber]struct{}
+	active time.Time
+}
+
+type sseSubscriber struct {
+	topics  []string
+	ch      chan Event
+	dropped chan struct{}
+}
+
+// SSEBroker fans events published to topics out to subscribed http clients
+type SSEBroker struct {
+	opts   SSEBrokerOptions
+	mu     sync.Mutex
+	seq    uint64
+	topics map[string]*sseTopic
+	swept  time.Time
+}
+
+// NewSSEBroker returns an SSEBroker. Serve it over http to let clients subscribe.
+func NewSSEBroker(opts SSEBrokerOptions) *SSEBroker {
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = 15 * time.Second
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 100
+	}
+	if opts.ClientBuffer <= 0 {
+		opts.ClientBuffer = 16
+	}
+	if opts.TopicTTL <= 0 {
+		opts.TopicTTL = 10 * time.Minute
+	}
+	return &SSEBroker{opts: opts, topics: map[string]*sseTopic{}, swept: time.Now()}
+}
+
+func (b *SSEBroker) topic(name string) *sseTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &sseTopic{subs: map[*sseSubscriber]struct{}{}}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// unsubscribe removes s from all of its topics, deleting topics left without subscribers or buffered events.
+// b.mu must be held.
+func (b *SSEBroker) unsubscribe(s *sseSubscriber) {
+	for _, name := range s.topics {
+		t, ok := b.topics[name]
+		if !ok {
+			continue
+		}
+		delete(t.subs, s)
+		if len(t.subs) == 0 {
+			if len(t.events) == 0 {
+				delete(b.topics, name)
+			}
+			t.active = time.Now()
+		}
+	}
+}
+
+// sweep deletes topics that have had no subscribers or events for TopicTTL. It runs at most every TopicTTL/2 and
+// b.mu must be held.
+func (b *SSEBroker) sweep(now time.Time) {
+	if now.Sub(b.swept) < b.opts.TopicTTL/2 {
+		return
+	}
+	b.swept = now
+	for name, t := range b.topics {
+		if len(t.subs) == 0 && now.Sub(t.active) > b.opts.TopicTTL {
+			delete(b.topics, name)
+		}
+	}
+}
+
+// ForgetTopic deletes the buffered events of topic, and the topic itself if it has no subscribers. Clients that are
+// subscribed stay subscribed.
+func (b *SSEBroker) ForgetTopic(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return
+	}
+	t.events, t.seqs = nil, nil
+	if len(t.subs) == 0 {
+		delete(b.topics, topic)
+	}
+}
+
+func (b *SSEBroker) allowed(topic string) bool {
+	if len(b.opts.Topics) == 0 {
+		return true
+	}
+	for _, t := range b.opts.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends e to every subscriber of topic and returns it with its ID set. IDs are assigned by the broker from a
+// sequence shared by all topics so clients can resume with Last-Event-ID.
+func (b *SSEBroker) Publish(topic string, e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.ID = strconv.FormatUint(b.seq, 10)
+	now := time.Now()
+	b.sweep(now)
+	t := b.topic(topic)
+	t.active = now
+	t.events = append(t.events, e)
+	t.seqs = append(t.seqs, b.seq)
+	if over := len(t.events) - b.opts.BufferSize; over > 0 {
+		t.events = append([]Event{}, t.events[over:]...)
+		t.seqs = append([]uint64{}, t.seqs[over:]...)
+	}
+	for s := range t.subs {
+		select {
+		case s.ch <- e:
+		default:
+			// too slow, disconnect it so it reconnects and replays from its last event. Removing it from every
+			// topic keeps a publish to its other topics from dropping it again.
+			close(s.dropped)
+			b.unsubscribe(s)
+		}
+	}
+	return e
+}
+
+// Subscribers returns the number of clients subscribed to topic
+func (b *SSEBroker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[topic]; ok {
+		return len(t.subs)
+	}
+	return 0
+}
+
+// ServeHTTP streams the events of the topics named by the topic query parameter, which may be repeated.
+// Buffered events newer than the Last-Event-ID header, or lastEventId query parameter, are replayed first.
+func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	var topics []string
+	seen := map[string]bool{}
+	for _, name := range r.URL.Query()["topic"] {
+		if seen[name] {
+			continue
+		}
+		if !b.allowed(name) {
+			http.Error(w, fmt.Sprintf("unknown topic %q", name), http.StatusNotFound)
+			return
+		}
+		seen[name] = true
+		topics = append(topics, name)
+	}
+	if len(topics) == 0 {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	var last uint64
+	replay := false
+	if lastID != "" {
+		if n, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+			last, replay = n, true
+		}
+	}
+
+	sub := &sseSubscriber{topics: topics, ch: make(chan Event, b.opts.ClientBuffer), dropped: make(chan struct{})}
+	type buffered struct {
+		seq uint64
+		e   Event
+	}
+	var backlog []buffered
+	b.mu.Lock()
+	b.sweep(time.Now())
+	for _, name := range topics {
+		t := b.topic(name)
+		if replay {
+			for i, seq := range t.seqs {
+				if seq > last {
+					backlog = append(backlog, buffered{seq, t.events[i]})
+				}
+			}
+		}
+		t.subs[sub] = struct{}{}
+	}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.unsubscribe(sub)
+		b.mu.Unlock()
+	}()
+	sort.Slice(backlog, func(i, j int) bool { return backlog[i].seq < backlog[j].seq })
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range backlog {
+		if _, err := e.e.WriteTo(w); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(b.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.dropped:
+			return
+		case e := <-sub.ch:
+			if _, err := e.WriteTo(w); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// SSEClient subscribes to a server-sent event stream. The stream is reopened after Retry, 3 seconds by default or
+// as set by the server, whenever it ends, resuming from LastEventID.
+type SSEClient struct {
+	URL         string
+	Headers     map[string]string
+	HTTPClient  *http.Client
+	LastEventID string
+	Retry       time.Duration
+}
+
+// Subscribe calls fn with every event received until ctx is done, fn returns an error, or the server answers with a
+// client error status
+func (c *SSEClient) Subscribe(ctx context.Context, fn func(Event) error) error {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Retry <= 0 {
+		c.Retry = 3 * time.Second
+	}
+	for {
+		err := c.stream(ctx, client, fn)
+		if stop, ok := err.(*sseStopError); ok {
+			return stop.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := sleepCtx(ctx, c.Retry); err != nil {
+			return err
+		}
+	}
+}
+
+type sseStopError struct {
+	err error
+}
+
+func (s *sseStopError) Error() string {
+	return s.err.Error()
+}
+
+func (c *SSEClient) stream(ctx context.Context, client *http.Client, fn func(Event) error) error {
+	headers := map[string]string{"Accept": "text/event-stream", "Cache-Control": "no-cache"}
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	if c.LastEventID != "" {
+		headers["Last-Event-ID"] = c.LastEventID
+	}
+	req, err := NewRequestCtx(ctx, http.MethodGet, c.URL, "", "", headers, nil, nil)
+	if err != nil {
+		return &sseStopError{err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &sseStopError{fmt.Errorf("sse: %s returned %s", c.URL, resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sse: %s returned %s", c.URL, resp.Status)
+	}
+	return ReadEvents(resp.Body, func(e Event) error {
+		if e.ID != "" {
+			c.LastEventID = e.ID
+		}
+		if e.Retry > 0 {
+			c.Retry = e.Retry
+		}
+		if e.Data == "" && e.Event == "" {
+			return nil
+		}
+		if err := fn(e); err != nil {
+			return &sseStopError{err}
+		}
+		return nil
+	})
+}
+
+// ReadEvents parses a text/event-stream from r, calling fn with each event until r ends or fn returns an error
+func ReadEvents(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var (
+		e       Event
+		data    []string
+		pending bool
+	)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if pending {
+				e.Data = strings.Join(data, "\n")
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			e, data, pending = Event{}, nil, false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			e.ID = value
+		case "event":
+			e.Event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				e.Retry = time.Duration(ms) * time.Millisecond
+			}
+		default:
+			continue
+		}
+		pending = true
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingRecorder stalls every write until release is closed, simulating a client that stopped reading
+type blockingRecorder struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (b *blockingRecorder) Write(p []byte) (int, error) {
+	<-b.release
+	return b.ResponseRecorder.Write(p)
+}
+
+func serveSSE(t *testing.T, b *SSEBroker, w http.ResponseWriter, target string) (cancel func(), done chan struct{}) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		b.ServeHTTP(w, r)
+	}()
+	return cancel, done
+}
+
+func waitSubscribers(t *testing.T, b *SSEBroker, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Subscribers(topic) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers of %s, got %d", n, topic, b.Subscribers(topic))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSSEBrokerDropsSlowSubscriberOfSeveralTopics(t *testing.T) {
+	b := NewSSEBroker(SSEBrokerOptions{ClientBuffer: 1})
+	w := &blockingRecorder{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+	cancel, done := serveSSE(t, b, w, "/events?topic=a&topic=b")
+	defer cancel()
+	waitSubscribers(t, b, "a", 1)
+
+	for i := 0; i < 10; i++ {
+		b.Publish("a", Event{Data: "a"})
+		b.Publish("b", Event{Data: "b"})
+	}
+	if n := b.Subscribers("a") + b.Subscribers("b"); n != 0 {
+		t.Fatalf("expected the slow subscriber to be dropped from every topic, %d subscriptions left", n)
+	}
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("dropped subscriber was not disconnected")
+	}
+}
+
+func TestSSEBrokerDeletesUnusedTopics(t *testing.T) {
+	b := NewSSEBroker(SSEBrokerOptions{})
+	cancel, done := serveSSE(t, b, httptest.NewRecorder(), "/events?topic=x&topic=y")
+	waitSubscribers(t, b, "x", 1)
+	cancel()
+	<-done
+
+	b.mu.Lock()
+	n := len(b.topics)
+	b.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected topics without subscribers or events to be deleted, %d left", n)
+	}
+}
+
+func TestSSEBrokerTopicAllowlist(t *testing.T) {
+	b := NewSSEBroker(SSEBrokerOptions{Topics: []string{"news"}})
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events?topic=other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a topic not in the allowlist, got %d", w.Code)
+	}
+}
+
+func TestSSEBrokerReplay(t *testing.T) {
+	b := NewSSEBroker(SSEBrokerOptions{})
+	first := b.Publish("a", Event{Data: "one"})
+	b.Publish("a", Event{Data: "two"})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client := &SSEClient{URL: srv.URL + "?topic=a", LastEventID: first.ID}
+	var got []string
+	err := client.Subscribe(ctx, func(e Event) error {
+		got = append(got, e.Data)
+		return errStopSSE
+	})
+	if err != errStopSSE {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != "two" {
+		t.Fatalf("expected only the event after Last-Event-ID to be replayed, got %v", got)
+	}
+}
+
+var errStopSSE = errors.New("stop")
+
+func TestSSEBrokerExpiresIdleTopics(t *testing.T) {
+	b := NewSSEBroker(SSEBrokerOptions{TopicTTL: 20 * time.Millisecond})
+	b.Publish("entity-1", Event{Data: "one"})
+	b.Publish("entity-2", Event{Data: "two"})
+	b.ForgetTopic("entity-2")
+	time.Sleep(30 * time.Millisecond)
+	b.Publish("entity-3", Event{Data: "three"})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.topics) != 1 || b.topics["entity-3"] == nil {
+		t.Fatalf("expected only the active topic to be kept, got %d topics", len(b.topics))
+	}
+}
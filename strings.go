@@ -286,16 +286,25 @@ func ScanAndReplace(r io.Reader, replacements ...string) string {
 }
 
 func Render(s string, data interface{}) string {
-	if strings.Contains(s, "{{") {
-		t, err := template.New("").Funcs(sprig.GenericFuncMap()).Parse(s)
-		FatalIfErr(err, "failed to create template to render string", s)
-		buf := bytes.NewBuffer(nil)
-		if err := t.Execute(buf, data); err != nil {
-			FatalIfErr(err, "failed to render string at execution", s)
-		}
-		return buf.String()
+	out, err := RenderErr(s, data)
+	FatalIfErr(err, "failed to render string", s)
+	return out
+}
+
+// RenderErr is Render returning an error instead of exiting if s is not a valid template or fails to execute
+func RenderErr(s string, data interface{}) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("").Funcs(sprig.GenericFuncMap()).Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("failed to create template to render string: %s", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to render string at execution: %s", err)
 	}
-	return s
+	return buf.String(), nil
 }
 
 func HorizontalLine() {
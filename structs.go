@@ -3,7 +3,6 @@ package util
 import (
 	"fmt"
 	"github.com/fatih/structs"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"reflect"
 )
@@ -51,26 +50,35 @@ func (s *Struct) StructUninitialized(obj interface{}, name string) bool {
 }
 
 func (s *Struct) Flagify(obj interface{}) {
-	fields := s.StructFields(obj)
-	for _, f := range fields {
-		if f.IsZero() {
-			switch f.Kind() {
-			case reflect.String:
-				err := f.Set(pflag.String(f.Name(), "", fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())))
-				logrus.Warnln("failed to reflect flags to struct", err.Error())
-			case reflect.Bool:
-				err := f.Set(pflag.Bool(f.Name(), false, fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())))
-				logrus.Warnln("failed to reflect flags to struct", err.Error())
-			case reflect.Int:
-				err := f.Set(pflag.Int(f.Name(), 0, fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())))
-				logrus.Warnln("failed to reflect flags to struct", err.Error())
-			case reflect.Slice:
-				err := f.Set(pflag.StringSlice(f.Name(), []string{}, fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())))
-				logrus.Warnln("failed to reflect flags to struct", err.Error())
-			case reflect.Map:
-				err := f.Set(pflag.StringToString(f.Name(), make(map[string]string), fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())))
-				logrus.Warnln("failed to reflect flags to struct", err.Error())
-			}
+	if err := s.FlagifyErr(obj); err != nil {
+		GetLogger().Warn("failed to reflect flags to struct", Fields{"error": err})
+	}
+}
+
+// FlagifyErr is Flagify returning the failures of every field as a MultiError instead of logging them
+func (s *Struct) FlagifyErr(obj interface{}) error {
+	var errs MultiError
+	for _, f := range s.StructFields(obj) {
+		if !f.IsZero() {
+			continue
+		}
+		usage := fmt.Sprintf("name: %s kind: %s", f.Name(), f.Kind())
+		var err error
+		switch f.Kind() {
+		case reflect.String:
+			err = f.Set(pflag.String(f.Name(), "", usage))
+		case reflect.Bool:
+			err = f.Set(pflag.Bool(f.Name(), false, usage))
+		case reflect.Int:
+			err = f.Set(pflag.Int(f.Name(), 0, usage))
+		case reflect.Slice:
+			err = f.Set(pflag.StringSlice(f.Name(), []string{}, usage))
+		case reflect.Map:
+			err = f.Set(pflag.StringToString(f.Name(), make(map[string]string), usage))
+		}
+		if err != nil {
+			errs.Append(fmt.Errorf("field %s: %w", f.Name(), err))
 		}
 	}
+	return errs.ErrorOrNil()
 }
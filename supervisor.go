@@ -0,0 +1,311 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RestartPolicy decides whether a supervised process is restarted after it exits
+type RestartPolicy int
+
+const (
+	// RestartOnFailure restarts processes that exit with a non-zero code or fail to start
+	RestartOnFailure RestartPolicy = iota
+	// RestartAlways restarts processes whenever they exit
+	RestartAlways
+	// RestartNever leaves processes stopped once they exit
+	RestartNever
+)
+
+// ProcessState is the lifecycle state of a supervised process
+type ProcessState string
+
+const (
+	ProcessStarting ProcessState = "starting"
+	ProcessRunning  ProcessState = "running"
+	ProcessBackoff  ProcessState = "backoff"
+	ProcessExited   ProcessState = "exited"
+	ProcessFailed   ProcessState = "failed"
+	ProcessStopped  ProcessState = "stopped"
+)
+
+// ProcessSpec describes a process run by a Supervisor. MinBackoff and MaxBackoff default to one and thirty seconds.
+type ProcessSpec struct {
+	Name       string
+	Command    string
+	Args       []string
+	Dir        string
+	Env        []string
+	Restart    RestartPolicy
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ProcessStatus is a point in time view of a supervised process
+type ProcessStatus struct {
+	Name      string       `json:"name"`
+	State     ProcessState `json:"state"`
+	Pid       int          `json:"pid,omitempty"`
+	Restarts  int          `json:"restarts"`
+	ExitCode  int          `json:"exit_code"`
+	Error     string       `json:"error,omitempty"`
+	StartedAt time.Time    `json:"started_at,omitempty"`
+}
+
+type process struct {
+	spec   ProcessSpec
+	mu     sync.Mutex
+	status ProcessStatus
+	stdout *prefixWriter
+	stderr *prefixWriter
+}
+
+func (p *process) update(fn func(s *ProcessStatus)) {
+	p.mu.Lock()
+	fn(&p.status)
+	p.mu.Unlock()
+}
+
+// Supervisor runs a set of named processes, restarting them with exponential backoff when they exit and
+// stopping them with SIGTERM, followed by SIGKILL after GracePeriod, on shutdown.
+// Process output is written line by line to Output, prefixed with the process name. Process starts, exits and
+// restarts are logged through GetLogger.
+type Supervisor struct {
+	Output      io.Writer
+	GracePeriod time.Duration
+	procs       []*process
+	mu          *sync.Mutex
+}
+
+// NewSupervisor returns a Supervisor for the given processes, writing their output to os.Stdout if out is nil
+func NewSupervisor(out io.Writer, grace time.Duration, specs ...ProcessSpec) *Supervisor {
+	if out == nil {
+		out = os.Stdout
+	}
+	if grace <= 0 {
+		grace = 10 * time.Second
+	}
+	width := 0
+	for _, spec := range specs {
+		if len(spec.Name) > width {
+			width = len(spec.Name)
+		}
+	}
+	s := &Supervisor{Output: out, GracePeriod: grace, mu: &sync.Mutex{}}
+	for _, spec := range specs {
+		if spec.MinBackoff <= 0 {
+			spec.MinBackoff = time.Second
+		}
+		if spec.MaxBackoff < spec.MinBackoff {
+			spec.MaxBackoff = 30 * time.Second
+		}
+		prefix := []byte(fmt.Sprintf("%-*s | ", width, spec.Name))
+		s.procs = append(s.procs, &process{
+			spec:   spec,
+			status: ProcessStatus{Name: spec.Name, State: ProcessStopped},
+			stdout: &prefixWriter{prefix: prefix, mu: s.mu, w: out},
+			stderr: &prefixWriter{prefix: prefix, mu: s.mu, w: out},
+		})
+	}
+	return s
+}
+
+// Run starts every process and blocks until ctx is done or SIGINT/SIGTERM is received, then stops them.
+// It also returns once every process has exited and none are due to be restarted.
+func (s *Supervisor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			GetLogger().Info("received signal, stopping processes", Fields{"signal": sig.String()})
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, p := range s.procs {
+		wg.Add(1)
+		go func(p *process) {
+			defer wg.Done()
+			s.supervise(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Supervisor) supervise(ctx context.Context, p *process) {
+	defer p.stdout.Flush()
+	defer p.stderr.Flush()
+	backoff := p.spec.MinBackoff
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
+		p.update(func(st *ProcessStatus) {
+			st.State = ProcessStarting
+			st.Restarts = restarts
+			st.StartedAt = started
+			st.Pid = 0
+		})
+		code, stopped, err := s.runOnce(ctx, p)
+		var pid int
+		p.update(func(st *ProcessStatus) {
+			pid = st.Pid
+			st.Pid = 0
+			st.ExitCode = code
+			st.Error = ""
+			switch {
+			case stopped:
+				st.State = ProcessStopped
+			case err != nil:
+				st.State = ProcessFailed
+				st.Error = err.Error()
+			default:
+				st.State = ProcessExited
+			}
+		})
+		if stopped {
+			return
+		}
+		fields := Fields{"process": p.spec.Name, "pid": pid, "exit_code": code}
+		if err != nil {
+			fields["error"] = err
+			GetLogger().Warn("process exited", fields)
+		} else {
+			GetLogger().Info("process exited", fields)
+		}
+		switch p.spec.Restart {
+		case RestartNever:
+			return
+		case RestartOnFailure:
+			if err == nil {
+				return
+			}
+		}
+		if time.Since(started) > p.spec.MaxBackoff {
+			backoff = p.spec.MinBackoff
+		}
+		p.update(func(st *ProcessStatus) { st.State = ProcessBackoff })
+		GetLogger().Info("restarting process", Fields{"process": p.spec.Name, "restart_in": backoff.String()})
+		if err := sleepCtx(ctx, backoff); err != nil {
+			p.update(func(st *ProcessStatus) { st.State = ProcessStopped })
+			return
+		}
+		if backoff *= 2; backoff > p.spec.MaxBackoff {
+			backoff = p.spec.MaxBackoff
+		}
+	}
+}
+
+// runOnce runs the process until it exits or ctx is done, in which case stopped is true
+func (s *Supervisor) runOnce(ctx context.Context, p *process) (code int, stopped bool, err error) {
+	cmd := exec.Command(p.spec.Command, p.spec.Args...)
+	cmd.Dir = p.spec.Dir
+	if p.spec.Env != nil {
+		cmd.Env = p.spec.Env
+	}
+	cmd.Stdout = p.stdout
+	cmd.Stderr = p.stderr
+	if err := cmd.Start(); err != nil {
+		return -1, false, err
+	}
+	p.update(func(st *ProcessStatus) {
+		st.State = ProcessRunning
+		st.Pid = cmd.Process.Pid
+	})
+	GetLogger().Info("process started", Fields{"process": p.spec.Name, "pid": cmd.Process.Pid})
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		stopped = true
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case err = <-done:
+		case <-time.After(s.GracePeriod):
+			GetLogger().Warn("process did not stop within the grace period, killing", Fields{
+				"process": p.spec.Name, "pid": cmd.Process.Pid, "grace_period": s.GracePeriod.String(),
+			})
+			cmd.Process.Kill()
+			err = <-done
+		}
+	}
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return exit.ExitCode(), stopped, err
+		}
+		return -1, stopped, err
+	}
+	return 0, stopped, nil
+}
+
+// Status returns the status of every process in the order they were given to NewSupervisor
+func (s *Supervisor) Status() []ProcessStatus {
+	out := make([]ProcessStatus, len(s.procs))
+	for i, p := range s.procs {
+		p.mu.Lock()
+		out[i] = p.status
+		p.mu.Unlock()
+	}
+	return out
+}
+
+// ServeHTTP writes the status of every process as json
+func (s *Supervisor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(ToPrettyJson(s.Status()))
+}
+
+// prefixWriter writes complete lines to w prefixed with prefix, holding mu so lines from different writers do not interleave
+type prefixWriter struct {
+	prefix []byte
+	mu     *sync.Mutex
+	w      io.Writer
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	lines := p.buf[:i+1]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, line := range strings.SplitAfter(string(lines), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := p.w.Write(append(append([]byte{}, p.prefix...), line...)); err != nil {
+			return 0, err
+		}
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	return len(b), nil
+}
+
+// Flush writes any trailing partial line
+func (p *prefixWriter) Flush() {
+	if len(p.buf) == 0 {
+		return
+	}
+	p.Write([]byte("\n"))
+}
@@ -0,0 +1,299 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// VCRMode controls whether a VCR replays recorded interactions or records new ones
+type VCRMode int
+
+const (
+	// VCRReplayOrRecord replays matching interactions and records any request without one
+	VCRReplayOrRecord VCRMode = iota
+	// VCRReplay only replays; requests without a matching interaction fail
+	VCRReplay
+	// VCRRecord sends every request and overwrites the cassette with the recorded interactions
+	VCRRecord
+)
+
+// DefaultVCRRedactedHeaders are replaced with VCRRedacted in recorded cassettes
+var DefaultVCRRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// VCRRedacted replaces the value of redacted headers
+const VCRRedacted = Redacted
+
+// RecordedRequest is the request half of a recorded interaction
+type RecordedRequest struct {
+	Method       string      `json:"method" yaml:"method"`
+	URL          string      `json:"url" yaml:"url"`
+	Header       http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body         string      `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty" yaml:"body_encoding,omitempty"`
+}
+
+// RecordedResponse is the response half of a recorded interaction
+type RecordedResponse struct {
+	StatusCode   int         `json:"status_code" yaml:"status_code"`
+	Header       http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body         string      `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty" yaml:"body_encoding,omitempty"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request" yaml:"request"`
+	Response RecordedResponse `json:"response" yaml:"response"`
+	used     bool
+}
+
+// VCRMatcher reports whether a recorded request matches an outgoing request with the given body
+type VCRMatcher func(req *http.Request, body []byte, recorded *RecordedRequest) bool
+
+// DefaultVCRMatcher matches on method, url and body
+func DefaultVCRMatcher(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	if req.Method != recorded.Method || req.URL.String() != recorded.URL {
+		return false
+	}
+	rbody, err := decodeVCRBody(recorded.Body, recorded.BodyEncoding)
+	return err == nil && bytes.Equal(body, rbody)
+}
+
+// VCR records http interactions to a cassette file and replays them, ie. to run integration tests offline.
+// Cassettes are yaml if the path ends in .yaml or .yml and json otherwise.
+type VCR struct {
+	Path          string
+	Mode          VCRMode
+	Matcher       VCRMatcher
+	RedactHeaders []string
+
+	mu           sync.Mutex
+	interactions []*Interaction
+}
+
+// NewVCR loads the cassette at path, which may not exist yet unless mode is VCRReplay
+func NewVCR(path string, mode VCRMode) (*VCR, error) {
+	v := &VCR{
+		Path:          path,
+		Mode:          mode,
+		Matcher:       DefaultVCRMatcher,
+		RedactHeaders: DefaultVCRRedactedHeaders,
+	}
+	if mode == VCRRecord {
+		return v, nil
+	}
+	bits, err := fs.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && mode != VCRReplay {
+			return v, nil
+		}
+		return nil, fmt.Errorf("vcr: failed to read cassette: %s", err)
+	}
+	if v.isYaml() {
+		err = yaml.Unmarshal(bits, &v.interactions)
+	} else {
+		err = json.Unmarshal(bits, &v.interactions)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vcr: failed to decode cassette %s: %s", path, err)
+	}
+	return v, nil
+}
+
+func (v *VCR) isYaml() bool {
+	ext := strings.ToLower(filepath.Ext(v.Path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Interactions returns the interactions in the cassette
+func (v *VCR) Interactions() []*Interaction {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]*Interaction{}, v.interactions...)
+}
+
+// Save writes the cassette to Path
+func (v *VCR) Save() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var (
+		bits []byte
+		err  error
+	)
+	if v.isYaml() {
+		bits, err = yaml.Marshal(v.interactions)
+	} else {
+		bits, err = json.MarshalIndent(v.interactions, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("vcr: failed to encode cassette: %s", err)
+	}
+	if dir := filepath.Dir(v.Path); dir != "" {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return fs.WriteFile(v.Path, bits, 0644)
+}
+
+// readVCRRequestBody returns the body of req without consuming it. The caller's request is never modified: the body
+// is read from a fresh copy from GetBody if possible, otherwise it is buffered onto a clone of req which is returned.
+func readVCRRequestBody(req *http.Request) ([]byte, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, req, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rc.Close()
+		body, err := ioutil.ReadAll(rc)
+		return body, req, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, clone, nil
+}
+
+// Tripperware returns client middleware that replays and records interactions according to Mode. Recorded
+// interactions are saved to the cassette as they happen.
+func (v *VCR) Tripperware() Tripperware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, req, err := readVCRRequestBody(req)
+			if err != nil {
+				return nil, err
+			}
+			if v.Mode != VCRRecord {
+				if in := v.match(req, body); in != nil {
+					return in.response(req)
+				}
+				if v.Mode == VCRReplay {
+					return nil, fmt.Errorf("vcr: no recorded interaction for %s %s", req.Method, req.URL)
+				}
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			respBody, err := ReadBody(resp)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+			v.record(req, body, resp, respBody)
+			if err := v.Save(); err != nil {
+				return nil, err
+			}
+			return resp, nil
+		})
+	}
+}
+
+func (v *VCR) match(req *http.Request, body []byte) *Interaction {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var found *Interaction
+	for _, in := range v.interactions {
+		if !v.Matcher(req, body, &in.Request) {
+			continue
+		}
+		if !in.used {
+			found = in
+			break
+		}
+		if found == nil {
+			found = in
+		}
+	}
+	if found != nil {
+		found.used = true
+	}
+	return found
+}
+
+func (v *VCR) record(req *http.Request, body []byte, resp *http.Response, respBody []byte) {
+	in := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: v.redact(req.Header),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     v.redact(resp.Header),
+		},
+		used: true,
+	}
+	in.Request.Body, in.Request.BodyEncoding = encodeVCRBody(body)
+	in.Response.Body, in.Response.BodyEncoding = encodeVCRBody(respBody)
+	v.mu.Lock()
+	v.interactions = append(v.interactions, in)
+	v.mu.Unlock()
+}
+
+func (v *VCR) redact(h http.Header) http.Header {
+	out := CloneHeader(h)
+	for _, k := range v.RedactHeaders {
+		if _, ok := out[http.CanonicalHeaderKey(k)]; ok {
+			out.Set(k, VCRRedacted)
+		}
+	}
+	return out
+}
+
+func (in *Interaction) response(req *http.Request) (*http.Response, error) {
+	body, err := decodeVCRBody(in.Response.Body, in.Response.BodyEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("vcr: failed to decode recorded body: %s", err)
+	}
+	header := CloneHeader(in.Response.Header)
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+		StatusCode:    in.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func encodeVCRBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func decodeVCRBody(s, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVCRDoesNotModifyRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+	vcr, err := NewVCR(filepath.Join(t.TempDir(), "cassette.json"), VCRRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := vcr.Tripperware()(http.DefaultTransport)
+
+	for _, getBody := range []bool{true, false} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("hello"))
+		if !getBody {
+			req.GetBody = nil
+		}
+		body := req.Body
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != "hello" {
+			t.Fatalf("expected the body to reach the server, got %q", got)
+		}
+		if req.Body != body {
+			t.Fatal("expected the caller's request body to be left alone")
+		}
+	}
+	if n := len(vcr.Interactions()); n != 2 {
+		t.Fatalf("expected 2 recorded interactions, got %d", n)
+	}
+}
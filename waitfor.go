@@ -0,0 +1,169 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WaitTarget is a dependency WaitFor blocks on until its check succeeds
+type WaitTarget struct {
+	Name  string
+	Check CheckFunc
+}
+
+// WaitProgress is reported after every attempt to reach a target
+type WaitProgress struct {
+	Target  string
+	Attempt int
+	Ready   bool
+	Err     error
+	Elapsed time.Duration
+	// Next is the delay before the next attempt. It is zero once the target is ready.
+	Next time.Duration
+}
+
+// WaitOptions configures WaitForOpts. Zero values fall back to the defaults used by WaitFor: a 250ms initial backoff
+// doubling up to 5s, a 2s per attempt timeout, no overall timeout beyond the context's, and progress logged through GetLogger.
+type WaitOptions struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	AttemptTimeout time.Duration
+	Timeout        time.Duration
+	Progress       func(WaitProgress)
+}
+
+// TCPTarget waits for a tcp connection to addr to succeed
+func TCPTarget(addr string) WaitTarget {
+	return WaitTarget{Name: "tcp://" + addr, Check: TCPCheck(addr)}
+}
+
+// HTTPTarget waits for a GET request to url to respond with status, or any 2xx status if status is 0
+func HTTPTarget(url string, status int) WaitTarget {
+	return WaitTarget{Name: url, Check: HTTPGetCheck(url, status)}
+}
+
+// FileTarget waits for path to exist
+func FileTarget(path string) WaitTarget {
+	return WaitTarget{Name: "file://" + path, Check: FileCheck(path)}
+}
+
+// ExecTarget waits for a command to exit with status 0
+func ExecTarget(name string, args ...string) WaitTarget {
+	return WaitTarget{Name: strings.TrimSpace("exec:" + name + " " + strings.Join(args, " ")), Check: ExecCheck(name, "", args...)}
+}
+
+// ParseWaitTarget parses a target from a string, ie. from a flag or environment variable. Supported forms are
+// tcp://host:port, http(s)://host/path (optionally with #status to match a specific status code), file:///path and exec:command args...
+func ParseWaitTarget(spec string) (WaitTarget, error) {
+	switch {
+	case strings.HasPrefix(spec, "exec:"):
+		fields := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(fields) == 0 {
+			return WaitTarget{}, fmt.Errorf("wait: empty command in %q", spec)
+		}
+		return ExecTarget(fields[0], fields[1:]...), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return WaitTarget{}, fmt.Errorf("wait: invalid target %q: %s", spec, err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		return TCPTarget(u.Host), nil
+	case "http", "https":
+		status := 0
+		if u.Fragment != "" {
+			if _, err := fmt.Sscanf(u.Fragment, "%d", &status); err != nil {
+				return WaitTarget{}, fmt.Errorf("wait: invalid status in %q", spec)
+			}
+			u.Fragment = ""
+		}
+		return HTTPTarget(u.String(), status), nil
+	case "file":
+		return FileTarget(u.Path), nil
+	}
+	return WaitTarget{}, fmt.Errorf("wait: unsupported target %q", spec)
+}
+
+// WaitFor blocks until every target is reachable or ctx is done, retrying each with exponential backoff
+func WaitFor(ctx context.Context, targets ...WaitTarget) error {
+	return WaitForOpts(ctx, WaitOptions{}, targets...)
+}
+
+// WaitForOpts is WaitFor with options
+func WaitForOpts(ctx context.Context, opts WaitOptions, targets ...WaitTarget) error {
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 250 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = 5 * time.Second
+	}
+	if opts.AttemptTimeout <= 0 {
+		opts.AttemptTimeout = 2 * time.Second
+	}
+	if opts.Progress == nil {
+		opts.Progress = logWaitProgress
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	report := func(p WaitProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		opts.Progress(p)
+	}
+	start := time.Now()
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t WaitTarget) {
+			defer wg.Done()
+			backoff := opts.InitialBackoff
+			for attempt := 1; ; attempt++ {
+				attemptCtx, cancel := context.WithTimeout(ctx, opts.AttemptTimeout)
+				err := t.Check(attemptCtx)
+				cancel()
+				p := WaitProgress{Target: t.Name, Attempt: attempt, Ready: err == nil, Err: err, Elapsed: time.Since(start)}
+				if err == nil {
+					report(p)
+					return
+				}
+				p.Next = backoff
+				report(p)
+				if sleepCtx(ctx, backoff) != nil {
+					mu.Lock()
+					failed = append(failed, fmt.Sprintf("%s (%s)", t.Name, err))
+					mu.Unlock()
+					return
+				}
+				if backoff *= 2; backoff > opts.MaxBackoff {
+					backoff = opts.MaxBackoff
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("wait: %s waiting for %s", ctx.Err(), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func logWaitProgress(p WaitProgress) {
+	if p.Ready {
+		GetLogger().Info("target is ready", Fields{"target": p.Target, "elapsed": p.Elapsed.Round(time.Millisecond).String()})
+		return
+	}
+	GetLogger().Warn("target is not ready", Fields{"target": p.Target, "attempt": p.Attempt, "error": p.Err, "retry_in": p.Next.String()})
+}
@@ -0,0 +1,280 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IsWebsocketRequest reports whether r asks to upgrade the connection to a websocket
+func IsWebsocketRequest(r *http.Request) bool {
+	return HeaderContainsToken(r.Header, "Connection", "upgrade") && HeaderContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// WebsocketProxyOptions configures NewWebsocketProxy.
+// OnRequest is called with the handshake request after the director has rewritten it and may modify it or reject the
+// upgrade by returning an error. OnResponse is called with a successful handshake response from the upstream before it
+// is relayed and may modify it or abort the upgrade. IdleTimeout closes connections that carry no traffic in either
+// direction for that long. HandshakeTimeout defaults to 10 seconds.
+// Errors are passed to ErrorFunc if set; otherwise a rejected request is answered with 403 and a failed upstream with 502.
+type WebsocketProxyOptions struct {
+	Dial             func(ctx context.Context, network, addr string) (net.Conn, error)
+	TLSConfig        *tls.Config
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+	OnRequest        func(*http.Request) error
+	OnResponse       func(*http.Response) error
+	ErrorFunc        ErrorFunc
+}
+
+type websocketProxy struct {
+	director func(*http.Request)
+	opts     WebsocketProxyOptions
+}
+
+// NewWebsocketProxy returns a handler proxying websocket upgrades to the upstream chosen by director, ie. one from
+// ProxyRequestFunc. Once the upstream accepts the handshake the client connection is hijacked and spliced to it.
+func NewWebsocketProxy(director func(*http.Request), opts WebsocketProxyOptions) http.Handler {
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = 10 * time.Second
+	}
+	if opts.Dial == nil {
+		opts.Dial = (&net.Dialer{}).DialContext
+	}
+	return &websocketProxy{director: director, opts: opts}
+}
+
+// WebsocketProxy returns middleware sending websocket upgrades to NewWebsocketProxy and everything else to next
+func WebsocketProxy(director func(*http.Request), opts WebsocketProxyOptions) HandlerFunc {
+	ws := NewWebsocketProxy(director, opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsWebsocketRequest(r) {
+				ws.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewReverseProxy returns an httputil.ReverseProxy for director with websocket upgrades handled by NewWebsocketProxy
+func NewReverseProxy(director func(*http.Request), opts WebsocketProxyOptions) http.Handler {
+	return WebsocketProxy(director, opts)(&httputil.ReverseProxy{Director: director})
+}
+
+func (p *websocketProxy) fail(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if p.opts.ErrorFunc != nil {
+		p.opts.ErrorFunc(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func (p *websocketProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !IsWebsocketRequest(r) {
+		p.fail(w, r, http.StatusBadRequest, fmt.Errorf("websocket: not an upgrade request"))
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		p.fail(w, r, http.StatusInternalServerError, fmt.Errorf("websocket: response writer cannot be hijacked"))
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	if p.director != nil {
+		p.director(out)
+	}
+	RemoveHopHeaders(out.Header)
+	out.Header.Set("Connection", "Upgrade")
+	out.Header.Set("Upgrade", "websocket")
+	if p.opts.OnRequest != nil {
+		if err := p.opts.OnRequest(out); err != nil {
+			p.fail(w, r, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	upstream, resp, err := p.handshake(out)
+	if err != nil {
+		p.fail(w, r, http.StatusBadGateway, err)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// the upstream refused the upgrade, relay its answer
+		defer upstream.Close()
+		defer resp.Body.Close()
+		RemoveHopHeaders(resp.Header)
+		CopyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	if p.opts.OnResponse != nil {
+		if err := p.opts.OnResponse(resp); err != nil {
+			upstream.Close()
+			p.fail(w, r, http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	client, brw, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		p.fail(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := io.WriteString(client, "HTTP/1.1 101 Switching Protocols\r\n"); err == nil {
+		if err = resp.Header.Write(client); err == nil {
+			_, err = io.WriteString(client, "\r\n")
+		}
+	}
+	if err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	spliceConns(client, upstream, p.opts.IdleTimeout, brw.Reader, upstream.r, nil, nil)
+}
+
+type upstreamConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// handshake dials the upstream and sends it the upgrade request
+func (p *websocketProxy) handshake(out *http.Request) (*upstreamConn, *http.Response, error) {
+	ctx, cancel := context.WithTimeout(out.Context(), p.opts.HandshakeTimeout)
+	defer cancel()
+
+	secure := out.URL.Scheme == "https" || out.URL.Scheme == "wss"
+	addr := out.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if secure {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+	conn, err := p.opts.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: failed to dial %s: %s", addr, err)
+	}
+	if secure {
+		cfg := &tls.Config{}
+		if p.opts.TLSConfig != nil {
+			cfg = p.opts.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = out.URL.Hostname()
+		}
+		tconn := tls.Client(conn, cfg)
+		if err := tconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("websocket: tls handshake with %s failed: %s", addr, err)
+		}
+		conn = tconn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// the handshake has to be http/1.1 with the upgrade headers the director produced
+	switch out.URL.Scheme {
+	case "ws":
+		out.URL.Scheme = "http"
+	case "wss":
+		out.URL.Scheme = "https"
+	}
+	if err := out.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("websocket: failed to send handshake to %s: %s", addr, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, out)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("websocket: failed to read handshake response from %s: %s", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return &upstreamConn{Conn: conn, r: br}, resp, nil
+}
+
+// spliceConns copies data between a and b until either side closes or goes idle for longer than idle, then closes
+// both. Bytes already buffered in abuf and bbuf while reading a handshake are sent first; either may be nil.
+// The bytes copied each way are added to ab and ba when they are not nil.
+func spliceConns(a, b net.Conn, idle time.Duration, abuf, bbuf *bufio.Reader, ab, ba *int64) {
+	ia := &idleConn{Conn: a, timeout: idle}
+	ib := &idleConn{Conn: b, timeout: idle}
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			a.Close()
+			b.Close()
+		})
+	}
+	pipe := func(dst *idleConn, src *idleConn, buf *bufio.Reader, n *int64) {
+		defer closeBoth()
+		var r io.Reader = src
+		if buf != nil {
+			r = io.MultiReader(io.LimitReader(buf, int64(buf.Buffered())), src)
+		}
+		var w io.Writer = dst
+		if n != nil {
+			w = &countingConnWriter{w: dst, n: n}
+		}
+		io.Copy(w, r)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(ib, ia, abuf, ab)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(ia, ib, bbuf, ba)
+	}()
+	wg.Wait()
+}
+
+// countingConnWriter adds the bytes written through it to a counter shared between goroutines
+type countingConnWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingConnWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// idleConn pushes the deadline of a connection forward on every read and write
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(b)
+}